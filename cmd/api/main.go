@@ -2,18 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/danilevy1212/baseidx-wt/internal/config"
 	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 )
 
+// Bucket sizes supported by the balance history, keyed by their Postgres date_trunc name
+var historyIntervals = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+const maxHistoryBuckets = 1000
+
+var addressPattern = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
+
 func main() {
 	ctx := context.Background()
 	cfg, err := config.New(ctx)
@@ -25,7 +41,22 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating database client: %v", err)
 	}
+	defer db.Close()
 
+	rpcClient, err := rpc.NewClient(cfg.BaseAPI)
+	if err != nil {
+		log.Fatalf("Error creating rpc client: %v", err)
+	}
+
+	r := newRouter(db, rpcClient, cfg.Server.AdminToken)
+
+	if err := r.Run(fmt.Sprintf(":%d", cfg.Server.Port)); err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+}
+
+// newRouter registers every endpoint, db can be any storage backend.
+func newRouter(db database.Store, rpcClient *rpc.Client, adminToken string) *gin.Engine {
 	r := gin.Default()
 
 	r.GET("/health", func(c *gin.Context) {
@@ -34,6 +65,126 @@ func main() {
 		})
 	})
 
+	r.POST("/accounts", requireAdmin(adminToken), func(c *gin.Context) {
+		var body struct {
+			Address    string  `json:"address"`
+			StartBlock *uint64 `json:"startBlock"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid body: %v", err)})
+			return
+		}
+
+		address := strings.ToLower(body.Address)
+		if !addressPattern.MatchString(address) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address: must be 0x followed by 40 hex characters"})
+			return
+		}
+
+		tracked, added, err := db.AddTrackedAddress(c.Request.Context(), address, body.StartBlock)
+		if err != nil {
+			log.Printf("Error tracking address %s: %v", address, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !added {
+			c.JSON(http.StatusConflict, gin.H{"error": "address is already tracked"})
+			return
+		}
+
+		log.Printf("Tracking address %s, backfilling from block %v", address, body.StartBlock)
+
+		// The follower picks it up on its next poll, and schedules the backfill job from there
+		c.JSON(http.StatusAccepted, tracked)
+	})
+
+	r.POST("/jobs", requireAdmin(adminToken), func(c *gin.Context) {
+		var body struct {
+			Address   string  `json:"address"`
+			FromBlock *uint64 `json:"fromBlock"`
+			ToBlock   *uint64 `json:"toBlock"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid body: %v", err)})
+			return
+		}
+
+		if body.FromBlock == nil || body.ToBlock == nil || *body.FromBlock > *body.ToBlock {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fromBlock and toBlock are required, and fromBlock can't be after toBlock"})
+			return
+		}
+
+		job := database.Job{Kind: database.JobKindReindex, FromBlock: *body.FromBlock, ToBlock: body.ToBlock}
+		if body.Address != "" {
+			address := strings.ToLower(body.Address)
+			if !addressPattern.MatchString(address) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address: must be 0x followed by 40 hex characters"})
+				return
+			}
+			job.Address = &address
+		}
+
+		job, err := db.EnqueueJob(c.Request.Context(), job)
+		if err != nil {
+			log.Printf("Error enqueueing reindex of blocks %d to %d: %v", *body.FromBlock, *body.ToBlock, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, job)
+	})
+
+	r.GET("/jobs", requireAdmin(adminToken), func(c *gin.Context) {
+		var filter database.JobFilter
+
+		switch status := c.Query("status"); status {
+		case "", database.JobPending, database.JobRunning, database.JobDone, database.JobFailed:
+			filter.Status = status
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status: must be pending, running, done or failed"})
+			return
+		}
+
+		if limit := c.Query("limit"); limit != "" {
+			l, err := strconv.Atoi(limit)
+			if err != nil || l < 1 || l > database.MaxPageSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit: must be between 1 and %d", database.MaxPageSize)})
+				return
+			}
+			filter.Limit = l
+		}
+
+		jobs, err := db.GetJobs(c.Request.Context(), filter)
+		if err != nil {
+			log.Printf("Error listing jobs: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, jobs)
+	})
+
+	r.GET("/jobs/:id", requireAdmin(adminToken), func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id: must be a job number"})
+			return
+		}
+
+		job, found, err := db.GetJob(c.Request.Context(), id)
+		if err != nil {
+			log.Printf("Error getting job %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	})
+
 	r.GET("/accounts/:account/balance", func(c *gin.Context) {
 		account := strings.ToLower(c.Param("account"))
 		if account == "" {
@@ -41,7 +192,36 @@ func main() {
 			return
 		}
 
-		balance, err := db.GetBalance(ctx, account)
+		blockStr := c.Query("block")
+		atStr := c.Query("at")
+		if blockStr != "" && atStr != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only one of block and at can be given"})
+			return
+		}
+
+		response := gin.H{"account": account}
+
+		var asOf database.BalanceAsOf
+		if blockStr != "" {
+			block, err := strconv.ParseUint(blockStr, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid block: must be a block number"})
+				return
+			}
+			asOf.Block = &block
+			response["block"] = block
+		}
+		if atStr != "" {
+			at, err := parseTime(atStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid at: %v", err)})
+				return
+			}
+			asOf.Time = &at
+			response["at"] = at.Format(time.RFC3339)
+		}
+
+		balance, err := db.GetBalanceAsOf(c.Request.Context(), account, asOf)
 		if err != nil {
 			log.Printf("Error getting balance for account %s: %v", account, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
@@ -53,9 +233,65 @@ func main() {
 			return
 		}
 
+		response["balance"] = balance.Balance
+		c.JSON(http.StatusOK, response)
+	})
+
+	r.GET("/accounts/:account/balance/history", func(c *gin.Context) {
+		account := strings.ToLower(c.Param("account"))
+		if account == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account parameter is required"})
+			return
+		}
+
+		interval := c.DefaultQuery("interval", "day")
+		step, ok := historyIntervals[interval]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid interval: must be hour, day or week"})
+			return
+		}
+
+		startStr := c.Query("start")
+		endStr := c.Query("end")
+		if startStr == "" || endStr == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start and end parameters are required"})
+			return
+		}
+
+		start, err := parseTime(startStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid start: %v", err)})
+			return
+		}
+		end, err := parseTime(endStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid end: %v", err)})
+			return
+		}
+
+		if start.After(end) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start time must be before end time"})
+			return
+		}
+
+		if end.Sub(start)/step >= maxHistoryBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many buckets: at most %d %ss", maxHistoryBuckets, interval)})
+			return
+		}
+
+		points, err := db.GetBalanceHistory(c.Request.Context(), account, interval, start, end)
+		if err != nil {
+			log.Printf("Error getting balance history for account %s: %v", account, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
-			"account": account,
-			"balance": balance.Balance,
+			"account":  account,
+			"interval": interval,
+			"start":    start.Format(time.RFC3339),
+			"end":      end.Format(time.RFC3339),
+			"history":  points,
 		})
 	})
 
@@ -66,14 +302,143 @@ func main() {
 			return
 		}
 
-		result, err := db.GetTransactionsFromAddress(ctx, account)
+		filter, err := parseTransactionFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := db.GetTransactionsFromAddress(c.Request.Context(), account, filter)
 		if err != nil {
 			log.Printf("Error getting transactions and fees for account %s: %v", account, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		// The body stays a plain list, the next page is announced in a header
+		if result.Next != nil {
+			c.Header("X-Next-Cursor", result.Next.Encode())
+		}
+
+		c.JSON(http.StatusOK, result.Transactions)
+	})
+
+	r.GET("/accounts/:account/tokens", func(c *gin.Context) {
+		account := strings.ToLower(c.Param("account"))
+		if account == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account parameter is required"})
+			return
+		}
+
+		balances, err := db.GetTokenBalances(c.Request.Context(), account)
+		if err != nil {
+			log.Printf("Error getting token balances for account %s: %v", account, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		tokens := make([]gin.H, 0, len(balances))
+		for _, balance := range balances {
+			withTokenMetadata(c.Request.Context(), db, rpcClient, &balance)
+			tokens = append(tokens, tokenBalanceResponse(balance))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"account": account,
+			"tokens":  tokens,
+		})
+	})
+
+	r.GET("/accounts/:account/tokens/:contract/balance", func(c *gin.Context) {
+		account := strings.ToLower(c.Param("account"))
+		contract := strings.ToLower(c.Param("contract"))
+		if account == "" || contract == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account and contract parameters are required"})
+			return
+		}
+
+		balance, err := db.GetTokenBalance(c.Request.Context(), account, contract)
+		if err != nil {
+			log.Printf("Error getting balance of token %s for account %s: %v", contract, account, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if balance.Transfers <= 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found or no transfers of this token"})
+			return
+		}
+
+		withTokenMetadata(c.Request.Context(), db, rpcClient, &balance)
+
+		response := tokenBalanceResponse(balance)
+		response["account"] = account
+		c.JSON(http.StatusOK, response)
+	})
+
+	r.GET("/accounts/:account/nfts", func(c *gin.Context) {
+		account := strings.ToLower(c.Param("account"))
+		if account == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account parameter is required"})
+			return
+		}
+
+		holdings, err := db.GetNFTHoldings(c.Request.Context(), account)
+		if err != nil {
+			log.Printf("Error getting NFTs for account %s: %v", account, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		transfers, err := db.GetNFTTransfersFromAddress(c.Request.Context(), account)
+		if err != nil {
+			log.Printf("Error getting NFT transfers for account %s: %v", account, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		// Group the owned tokens by collection, holdings are already sorted by contract
+		collections := []gin.H{}
+		var tokens []gin.H
+		for i, holding := range holdings {
+			tokens = append(tokens, gin.H{
+				"tokenId": holding.TokenID,
+				"amount":  holding.Amount,
+			})
+
+			if i == len(holdings)-1 || holdings[i+1].Contract != holding.Contract {
+				collections = append(collections, gin.H{
+					"contract": holding.Contract,
+					"standard": holding.Standard,
+					"tokens":   tokens,
+				})
+				tokens = nil
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"account":     account,
+			"collections": collections,
+			"transfers":   transfers,
+		})
+	})
+
+	r.GET("/accounts/:account/token-transfers", func(c *gin.Context) {
+		account := strings.ToLower(c.Param("account"))
+		if account == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account parameter is required"})
+			return
+		}
+		token := strings.ToLower(c.Query("token"))
+
+		transfers, err := db.GetTokenTransfersFromAddress(c.Request.Context(), account, token)
+		if err != nil {
+			log.Printf("Error getting token transfers for account %s: %v", account, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, transfers)
 	})
 
 	r.GET("/transactions", func(c *gin.Context) {
@@ -101,22 +466,53 @@ func main() {
 			return
 		}
 
-		transactions, err := db.GetTransactionsInRange(ctx, start, end)
+		filter, err := parseTransactionFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if filter.Direction != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "direction is only supported for account transactions"})
+			return
+		}
+
+		result, err := db.GetTransactionsInRange(c.Request.Context(), start, end, filter)
 		if err != nil {
 			log.Printf("Error getting transactions in range %s to %s: %v", startStr, endStr, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"start":        start.Format(time.RFC3339),
 			"end":          end.Format(time.RFC3339),
-			"transactions": transactions,
-		})
+			"transactions": result.Transactions,
+		}
+		if result.Next != nil {
+			c.Header("X-Next-Cursor", result.Next.Encode())
+		}
+
+		c.JSON(http.StatusOK, response)
 	})
 
-	if err := r.Run(fmt.Sprintf(":%d", cfg.Server.Port)); err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	return r
+}
+
+// requireAdmin only lets through requests with the admin token as bearer token.
+func requireAdmin(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled, set ADMIN_TOKEN"})
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing admin token"})
+			return
+		}
+
+		c.Next()
 	}
 }
 
@@ -130,3 +526,114 @@ func parseTime(value string) (time.Time, error) {
 	}
 	return t, nil
 }
+
+// parseTransactionFilter reads the pagination and filter query parameters shared by the
+// transaction listing endpoints.
+func parseTransactionFilter(c *gin.Context) (database.TransactionFilter, error) {
+	var filter database.TransactionFilter
+
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > database.MaxPageSize {
+			return filter, fmt.Errorf("invalid limit: must be between 1 and %d", database.MaxPageSize)
+		}
+		filter.Limit = l
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		tc, err := database.DecodeTransactionCursor(cursor)
+		if err != nil {
+			return filter, err
+		}
+		filter.Cursor = tc
+	}
+
+	switch t := c.Query("type"); t {
+	case "", "transfer", "call", "fee":
+		filter.Type = t
+	default:
+		return filter, fmt.Errorf("invalid type: must be transfer, call or fee")
+	}
+
+	switch d := c.Query("direction"); d {
+	case "", "in", "out":
+		filter.Direction = d
+	default:
+		return filter, fmt.Errorf("invalid direction: must be in or out")
+	}
+
+	if success := c.Query("success"); success != "" {
+		s, err := strconv.ParseBool(success)
+		if err != nil {
+			return filter, fmt.Errorf("invalid success: must be true or false")
+		}
+		filter.Succesful = &s
+	}
+
+	for name, target := range map[string]**uint64{"from_block": &filter.FromBlock, "to_block": &filter.ToBlock} {
+		if value := c.Query(name); value != "" {
+			block, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: must be a block number", name)
+			}
+			*target = &block
+		}
+	}
+
+	if minValue := c.Query("min_value"); minValue != "" {
+		v, err := decimal.NewFromString(minValue)
+		if err != nil {
+			return filter, fmt.Errorf("invalid min_value: must be a number of wei")
+		}
+		filter.MinValue = &v
+	}
+
+	return filter, nil
+}
+
+// withTokenMetadata fills in the metadata of the token if it isn't cached yet, fetching it from
+// the token contract and caching it. Balances are still useful without it, so errors are only logged.
+func withTokenMetadata(ctx context.Context, db database.Store, rpcClient *rpc.Client, balance *database.TokenBalance) {
+	if balance.Metadata != nil {
+		return
+	}
+
+	fetched, err := rpcClient.GetTokenMetadata(ctx, balance.Token)
+	if err != nil {
+		log.Printf("Error fetching metadata of token %s: %v", balance.Token, err)
+		return
+	}
+
+	metadata := database.TokenMetadata{
+		Token:    balance.Token,
+		Name:     fetched.Name,
+		Symbol:   fetched.Symbol,
+		Decimals: fetched.Decimals,
+	}
+
+	if err := db.UpsertTokenMetadata(ctx, metadata); err != nil {
+		log.Printf("Error caching metadata of token %s: %v", balance.Token, err)
+	}
+
+	balance.Metadata = &metadata
+}
+
+func tokenBalanceResponse(balance database.TokenBalance) gin.H {
+	response := gin.H{
+		"token":      balance.Token,
+		"rawBalance": balance.Balance,
+		"transfers":  balance.Transfers,
+	}
+
+	// Without decimals we can't tell the unit of the raw balance
+	if balance.Metadata != nil {
+		response["name"] = balance.Metadata.Name
+		response["symbol"] = balance.Metadata.Symbol
+		response["decimals"] = balance.Metadata.Decimals
+		if balance.Metadata.Decimals != nil {
+			response["balance"] = balance.Balance.Shift(-*balance.Metadata.Decimals)
+		}
+	}
+
+	return response
+}
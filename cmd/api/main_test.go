@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer/indexertest"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	m.Run()
+}
+
+func TestTokenMetadataOfRevertingTokenIsCached(t *testing.T) {
+	account := rpctest.Address(1)
+	token := rpctest.Address(2)
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// The token implements none of the metadata functions
+	client, node, store := indexertest.Setup(t, rpctest.NewChain(10, start))
+	err := store.CommitBlock(context.Background(), "", database.IndexedBlock{
+		Block: database.Block{Number: 10, Hash: "0xa", Timestamp: start},
+		TokenTransfers: []database.TokenTransfer{{
+			TransactionHash: "0x1", Token: token, From: rpctest.Address(3), To: account,
+			Value: decimal.NewFromInt(42), BlockIndex: "0xa", BlockNumber: 10, Timestamp: start,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("CommitBlock: %v", err)
+	}
+
+	router := newRouter(store, client, "")
+	for range 2 {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/"+account+"/tokens/"+token+"/balance", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+
+	// name(), symbol() and decimals() once, the second request used the cache
+	if calls := node.Calls("eth_call"); calls != 3 {
+		t.Errorf("eth_call was called %d times, want 3", calls)
+	}
+}
+
+func TestJobsNeedAdminToken(t *testing.T) {
+	store := database.NewMemoryStore()
+	to := uint64(20)
+	job, err := store.EnqueueJob(context.Background(), database.Job{Kind: database.JobKindReindex, FromBlock: 10, ToBlock: &to})
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	jobPath := fmt.Sprintf("/jobs/%d", job.ID)
+
+	tests := []struct {
+		name, adminToken, path, authorization string
+		want                                  int
+	}{
+		{"list without token", "secret", "/jobs", "", http.StatusUnauthorized},
+		{"list with wrong token", "secret", "/jobs", "Bearer nope", http.StatusUnauthorized},
+		{"list with token", "secret", "/jobs", "Bearer secret", http.StatusOK},
+		{"get without token", "secret", jobPath, "", http.StatusUnauthorized},
+		{"get with token", "secret", jobPath, "Bearer secret", http.StatusOK},
+		{"admin disabled", "", "/jobs", "Bearer ", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newRouter(store, nil, tt.adminToken)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+var (
+	apiAccount = rpctest.Address(1)
+	apiOther   = rpctest.Address(2)
+	apiToken   = rpctest.Address(3)
+	apiStart   = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// apiBlock is block number with the transactions and token transfers placed in it.
+func apiBlock(number uint64, txs []database.Transaction, tokens []database.TokenTransfer) database.IndexedBlock {
+	timestamp := apiStart.Add(time.Duration(number) * 2 * time.Second)
+	blockIndex := fmt.Sprintf("0x%x", number)
+
+	for i := range txs {
+		txs[i].BlockIndex, txs[i].BlockNumber, txs[i].Timestamp = blockIndex, number, timestamp
+	}
+	for i := range tokens {
+		tokens[i].BlockIndex, tokens[i].BlockNumber, tokens[i].Timestamp = blockIndex, number, timestamp
+	}
+
+	return database.IndexedBlock{
+		Block:          database.Block{Number: number, Hash: fmt.Sprintf("0xb%d", number), Timestamp: timestamp},
+		Transactions:   txs,
+		TokenTransfers: tokens,
+	}
+}
+
+// apiRouter serves a store where the account received 1000 wei, sent 300 paying a fee of 21
+// and received 50 more, in blocks 10 to 12, and received 42 of a token with 1 decimal.
+func apiRouter(t *testing.T) (*gin.Engine, database.Store) {
+	t.Helper()
+
+	chain := rpctest.NewChain(10, apiStart)
+	chain.Contracts[apiToken] = map[string]string{
+		data.NameSelector:     fmt.Sprintf("0x%064x%064x%x%s", 0x20, 4, "Test", strings.Repeat("0", 56)),
+		data.SymbolSelector:   fmt.Sprintf("0x%064x%064x%x%s", 0x20, 3, "TST", strings.Repeat("0", 58)),
+		data.DecimalsSelector: fmt.Sprintf("0x%064x", 1),
+	}
+	client, _, store := indexertest.Setup(t, chain)
+
+	value := func(v int64) decimal.Decimal { return decimal.NewFromInt(v) }
+	blocks := []database.IndexedBlock{
+		apiBlock(10, []database.Transaction{{Hash: "0x01", Type: "transfer", From: apiOther, To: apiAccount, Value: value(1000), Succesful: true}}, nil),
+		apiBlock(11, []database.Transaction{
+			{Hash: "0x02", Type: "transfer", From: apiAccount, To: apiOther, Value: value(300), Succesful: true},
+			{Hash: "0x02_fee", Type: "fee", From: apiAccount, Value: value(21), Succesful: true},
+		}, nil),
+		apiBlock(12, []database.Transaction{{Hash: "0x03", Type: "transfer", From: apiOther, To: apiAccount, Value: value(50), Succesful: true}},
+			[]database.TokenTransfer{{TransactionHash: "0x03", LogIndex: 1, Token: apiToken, From: apiOther, To: apiAccount, Value: value(42)}}),
+	}
+	for _, block := range blocks {
+		if err := store.CommitBlock(context.Background(), "", block); err != nil {
+			t.Fatalf("CommitBlock: %v", err)
+		}
+	}
+
+	return newRouter(store, client, "secret"), store
+}
+
+func serve(router *gin.Engine, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestHandlers(t *testing.T) {
+	router, _ := apiRouter(t)
+	account := "/accounts/" + apiAccount
+
+	tests := []struct {
+		name, method, path, body, token string
+		wantStatus                      int
+		// Fragments of the body
+		want []string
+	}{
+		{name: "health", method: "GET", path: "/health", wantStatus: 200, want: []string{`"status":"OK"`}},
+
+		{name: "balance", method: "GET", path: account + "/balance", wantStatus: 200, want: []string{`"balance":"729"`}},
+		{name: "balance at a block", method: "GET", path: account + "/balance?block=11", wantStatus: 200, want: []string{`"balance":"679"`, `"block":11`}},
+		{name: "balance at a time", method: "GET", path: account + "/balance?at=2025-06-01T00:00:20Z", wantStatus: 200, want: []string{`"balance":"1000"`}},
+		{name: "balance with block and time", method: "GET", path: account + "/balance?block=11&at=2025-06-01T00:00:20Z", wantStatus: 400},
+		{name: "balance at an invalid block", method: "GET", path: account + "/balance?block=abc", wantStatus: 400},
+		{name: "balance of an unknown account", method: "GET", path: "/accounts/" + apiToken + "/balance", wantStatus: 404},
+		{name: "balance of a checksummed address", method: "GET", path: "/accounts/0x" + strings.ToUpper(apiAccount[2:]) + "/balance", wantStatus: 200, want: []string{`"balance":"729"`}},
+
+		{name: "history", method: "GET", path: account + "/balance/history?interval=hour&start=2025-06-01T00:00:00Z&end=2025-06-01T01:00:00Z", wantStatus: 200, want: []string{`"interval":"hour"`, `"history":[`}},
+		{name: "history with an invalid interval", method: "GET", path: account + "/balance/history?interval=year&start=2025-06-01T00:00:00Z&end=2025-06-02T00:00:00Z", wantStatus: 400},
+		{name: "history without start", method: "GET", path: account + "/balance/history?end=2025-06-02T00:00:00Z", wantStatus: 400},
+		{name: "history backwards", method: "GET", path: account + "/balance/history?start=2025-06-02T00:00:00Z&end=2025-06-01T00:00:00Z", wantStatus: 400},
+		{name: "history with too many buckets", method: "GET", path: account + "/balance/history?interval=hour&start=2020-01-01T00:00:00Z&end=2025-06-01T00:00:00Z", wantStatus: 400},
+
+		{name: "transactions", method: "GET", path: account + "/transactions", wantStatus: 200, want: []string{`"0x03"`, `"0x02_fee"`, `"0x01"`}},
+		{name: "received transactions", method: "GET", path: account + "/transactions?direction=in&min_value=100", wantStatus: 200, want: []string{`"0x01"`}},
+		{name: "transactions with an invalid type", method: "GET", path: account + "/transactions?type=mint", wantStatus: 400},
+		{name: "transactions with an invalid limit", method: "GET", path: account + "/transactions?limit=0", wantStatus: 400},
+		{name: "transactions with an invalid cursor", method: "GET", path: account + "/transactions?cursor=nope", wantStatus: 400},
+		{name: "transactions in range", method: "GET", path: "/transactions?start=2025-06-01T00:00:22Z&end=2025-06-01T00:00:24Z", wantStatus: 200, want: []string{`"0x03"`, `"0x02"`}},
+		{name: "transactions in range with a direction", method: "GET", path: "/transactions?start=2025-06-01T00:00:00Z&end=2025-06-02T00:00:00Z&direction=in", wantStatus: 400},
+		{name: "transactions in range without end", method: "GET", path: "/transactions?start=2025-06-01T00:00:00Z", wantStatus: 400},
+
+		{name: "token balances", method: "GET", path: account + "/tokens", wantStatus: 200, want: []string{`"symbol":"TST"`, `"rawBalance":"42"`, `"balance":"4.2"`}},
+		{name: "token balance", method: "GET", path: account + "/tokens/" + apiToken + "/balance", wantStatus: 200, want: []string{`"name":"Test"`, `"decimals":1`}},
+		{name: "balance of an unknown token", method: "GET", path: account + "/tokens/" + apiOther + "/balance", wantStatus: 404},
+		{name: "token transfers", method: "GET", path: account + "/token-transfers?token=" + apiToken, wantStatus: 200, want: []string{`"value":"42"`}},
+		{name: "NFTs", method: "GET", path: account + "/nfts", wantStatus: 200},
+
+		{name: "track an invalid address", method: "POST", path: "/accounts", body: `{"address": "0x1234"}`, token: "secret", wantStatus: 400},
+		{name: "track without token", method: "POST", path: "/accounts", body: `{"address": "` + apiOther + `"}`, wantStatus: 401},
+		{name: "track a checksummed address", method: "POST", path: "/accounts", body: `{"address": "0x` + strings.ToUpper(apiOther[2:]) + `", "startBlock": 5}`, token: "secret", wantStatus: 202, want: []string{`"address":"` + apiOther + `"`, `"startBlock":5`, `"backfillJob":1`}},
+		{name: "track again", method: "POST", path: "/accounts", body: `{"address": "` + apiOther + `"}`, token: "secret", wantStatus: 409},
+
+		{name: "reindex", method: "POST", path: "/jobs", body: `{"fromBlock": 10, "toBlock": 12}`, token: "secret", wantStatus: 202, want: []string{`"kind":"reindex"`, `"status":"pending"`}},
+		{name: "reindex backwards", method: "POST", path: "/jobs", body: `{"fromBlock": 12, "toBlock": 10}`, token: "secret", wantStatus: 400},
+		{name: "reindex without blocks", method: "POST", path: "/jobs", body: `{"address": "` + apiAccount + `"}`, token: "secret", wantStatus: 400},
+		{name: "reindex an invalid address", method: "POST", path: "/jobs", body: `{"address": "0x1", "fromBlock": 1, "toBlock": 2}`, token: "secret", wantStatus: 400},
+		{name: "jobs", method: "GET", path: "/jobs?status=pending&limit=1", token: "secret", wantStatus: 200, want: []string{`"kind":"reindex"`}},
+		{name: "jobs with an invalid status", method: "GET", path: "/jobs?status=stuck", token: "secret", wantStatus: 400},
+		{name: "jobs with an invalid limit", method: "GET", path: "/jobs?limit=5000", token: "secret", wantStatus: 400},
+		{name: "job", method: "GET", path: "/jobs/1", token: "secret", wantStatus: 200, want: []string{`"kind":"backfill"`}},
+		{name: "job with an invalid id", method: "GET", path: "/jobs/first", token: "secret", wantStatus: 400},
+		{name: "unknown job", method: "GET", path: "/jobs/999", token: "secret", wantStatus: 404},
+	}
+
+	// In order, the later ones see what the earlier ones added
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body, tt.token)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			for _, fragment := range tt.want {
+				if !strings.Contains(w.Body.String(), fragment) {
+					t.Errorf("body %s doesn't contain %s", w.Body, fragment)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionsPagination(t *testing.T) {
+	router, _ := apiRouter(t)
+
+	tests := []struct {
+		name, path string
+		// The range listing wraps the transactions in an object
+		wrapped bool
+		want    []string
+	}{
+		{"account", "/accounts/" + apiAccount + "/transactions?limit=1", false, []string{"0x03", "0x02_fee", "0x02", "0x01"}},
+		{"range", "/transactions?start=2025-06-01T00:00:00Z&end=2025-06-02T00:00:00Z&limit=2&type=transfer", true, []string{"0x03", "0x02", "0x01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			path := tt.path
+			for pages := 0; pages <= len(tt.want); pages++ {
+				w := serve(router, http.MethodGet, path, "", "")
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d: %s", w.Code, w.Body)
+				}
+
+				var page struct{ Transactions []database.Transaction }
+				var err error
+				if tt.wrapped {
+					err = json.Unmarshal(w.Body.Bytes(), &page)
+				} else {
+					err = json.Unmarshal(w.Body.Bytes(), &page.Transactions)
+				}
+				if err != nil {
+					t.Fatalf("decoding %s: %v", w.Body, err)
+				}
+				for _, tx := range page.Transactions {
+					got = append(got, tx.Hash)
+				}
+
+				cursor := w.Header().Get("X-Next-Cursor")
+				if cursor == "" {
+					break
+				}
+				path = tt.path + "&cursor=" + cursor
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
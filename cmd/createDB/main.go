@@ -16,17 +16,19 @@ func main() {
 		log.Fatal("Error parsing config", err)
 	}
 
-	log.Printf("Config: %+v", cfg)
+	log.Printf("Config: %s", cfg)
 
 	dbClient, err := database.New(ctx, cfg.Database)
 	if err != nil {
 		log.Fatal("Error creating database client", err)
 	}
-	defer dbClient.Close(ctx)
+	defer dbClient.Close()
 
-	if err := dbClient.CreateSchema(ctx); err != nil {
-		log.Fatal("Error creating schema: ", err)
+	// Same as `migrate up`, safe to run on an existing database
+	applied, err := dbClient.Migrate(ctx)
+	if err != nil {
+		log.Fatal("Error migrating schema: ", err)
 	}
 
-	log.Println("Database schema created successfully")
+	log.Printf("Database schema up to date, %d migrations applied", len(applied))
 }
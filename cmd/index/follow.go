@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer"
+)
+
+// follow keeps indexing new blocks as they reach the configured confirmation depth,
+// until the context is cancelled. Addresses registered through the API are picked up on
+// every poll, and the jobs of the queue, like their backfills, are run in the background.
+func (a *app) follow(ctx context.Context, cfg config.IndexerConfig, configured map[string]bool) error {
+	job := cfg.JobName()
+
+	next, err := a.followStart(ctx, job, cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Job %s following the chain from block %d, %d confirmations, polling every %s", job, next, cfg.Confirmations, cfg.PollInterval)
+
+	// Cancelled first, then waited for, when following stops
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	workersCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.runWorkers(workersCtx, cfg, configured)
+	}()
+
+	accounts := configured
+	for {
+		a.rpcClient.CheckHealth(ctx)
+
+		if tracked, err := a.followAccounts(ctx, configured, next); err != nil {
+			log.Printf("Error refreshing the accounts, keeping the previous ones: %v", err)
+		} else {
+			accounts = tracked
+		}
+
+		latest, err := a.getLatestBlock(ctx)
+		if err != nil {
+			log.Printf("Error polling the chain head: %v", err)
+		} else if latest >= cfg.Confirmations {
+			head := latest - cfg.Confirmations
+
+			for next <= head && ctx.Err() == nil {
+				advanced, err := a.followRange(ctx, job, next, head, cfg, accounts)
+				if err != nil {
+					return err
+				}
+				// Don't skip over a block we failed to index, retry it on the next poll instead
+				if advanced == next {
+					break
+				}
+				next = advanced
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped following the chain, next block would have been %d", next)
+			return nil
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+// followRange indexes the blocks between next and head on top of the chain we already stored,
+// and returns the next block to index. It stops early when a block fails or a reorg is found,
+// in which case the orphaned blocks are rolled back and the returned block is the first one
+// that has to be re-indexed.
+func (a *app) followRange(ctx context.Context, job string, next, head uint64, cfg config.IndexerConfig, accounts map[string]bool) (uint64, error) {
+	for fetched := range a.blockIndexer.FetchBlocks(ctx, indexer.BlockRange(next, head), cfg.Concurrency, accounts) {
+		if fetched.Err != nil {
+			log.Printf("Error processing block %d: %v", fetched.Number, fetched.Err)
+			return next, nil
+		}
+
+		forkIdx, err := a.followBlock(ctx, job, fetched, cfg.MaxReorgDepth)
+		if err != nil {
+			return next, nil
+		}
+
+		if forkIdx != nil {
+			log.Printf("Rolling back job %s to block %d", job, *forkIdx)
+
+			if err := a.dbClient.RollbackBlocks(ctx, job, *forkIdx); err != nil {
+				return next, fmt.Errorf("error rolling back to block %d: %w", *forkIdx, err)
+			}
+
+			return *forkIdx, nil
+		}
+
+		next = fetched.Number + 1
+	}
+
+	return next, nil
+}
+
+// followBlock stores the fetched block if it builds on top of the block we stored before it.
+// Otherwise nothing is stored and the number of the first block that has to be re-indexed is
+// returned instead.
+func (a *app) followBlock(ctx context.Context, job string, fetched indexer.FetchedBlock, maxReorgDepth uint64) (*uint64, error) {
+	block := fetched.Indexed.Block
+
+	if block.Number > 0 {
+		parent, found, err := a.dbClient.GetBlock(ctx, block.Number-1)
+		if err != nil {
+			log.Printf("Error getting stored parent of block %d: %v", block.Number, err)
+			return nil, err
+		}
+
+		if found && parent.Hash != block.ParentHash {
+			log.Printf("Reorg detected at block %d: parent hash %s, stored %s", block.Number, block.ParentHash, parent.Hash)
+
+			forkIdx, err := a.findForkPoint(ctx, block.Number-1, maxReorgDepth)
+			if err != nil {
+				return nil, err
+			}
+			return &forkIdx, nil
+		}
+	}
+
+	return nil, a.blockIndexer.Commit(ctx, job, fetched.Indexed)
+}
+
+// findForkPoint walks back from blockIdx until the stored block matches the canonical chain,
+// and returns the first block after that common ancestor.
+func (a *app) findForkPoint(ctx context.Context, blockIdx uint64, maxDepth uint64) (uint64, error) {
+	for depth := uint64(0); depth < maxDepth; depth++ {
+		stored, found, err := a.dbClient.GetBlock(ctx, blockIdx)
+		if err != nil {
+			return 0, err
+		}
+		// Nothing stored this far back, so nothing else to roll back either
+		if !found {
+			return blockIdx + 1, nil
+		}
+
+		canonical, err := a.rpcClient.GetBlockHeaderByNumber(ctx, *data.NewHexFromUint64(blockIdx))
+		if err != nil {
+			return 0, fmt.Errorf("error getting canonical block %d: %w", blockIdx, err)
+		}
+
+		if canonical.Result.Hash == stored.Hash {
+			return blockIdx + 1, nil
+		}
+
+		log.Printf("Block %d was orphaned: stored %s, canonical %s", blockIdx, stored.Hash, canonical.Result.Hash)
+
+		if blockIdx == 0 {
+			return 0, nil
+		}
+		blockIdx--
+	}
+
+	return 0, fmt.Errorf("no common ancestor found within %d blocks of block %d", maxDepth, blockIdx+maxDepth)
+}
+
+// followStart picks the first block to index, in order of preference: the block after the
+// checkpoint of the job, the block after the last one we indexed, the configured START_BLOCK
+// or the current safe head.
+func (a *app) followStart(ctx context.Context, job string, cfg config.IndexerConfig) (uint64, error) {
+	checkpoint, found, err := a.dbClient.GetCheckpoint(ctx, job)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return checkpoint + 1, nil
+	}
+
+	last, found, err := a.dbClient.GetLastIndexedBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return last + 1, nil
+	}
+
+	if cfg.StartBlock > 0 {
+		return cfg.StartBlock, nil
+	}
+
+	latest, err := a.getLatestBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if latest < cfg.Confirmations {
+		return 0, nil
+	}
+
+	return latest - cfg.Confirmations, nil
+}
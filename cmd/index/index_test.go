@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer/indexertest"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+var (
+	account  = rpctest.Address(1)
+	other    = rpctest.Address(2)
+	accounts = map[string]bool{account: true}
+	start    = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// setup points the indexer to a fake node serving the chain and to an empty in-memory store.
+func setup(t *testing.T, chain *rpctest.Chain) (*app, *rpctest.Node, *database.MemoryStore) {
+	t.Helper()
+
+	client, node, store := indexertest.Setup(t, chain)
+
+	return newApp(client, store), node, store
+}
+
+// scriptedChain has activity of the account spread over 10 blocks.
+func scriptedChain() *rpctest.Chain {
+	chain := rpctest.NewChain(1_000, start)
+	chain.Genesis[account] = rpctest.Wei(1_000_000_000_000_000)
+
+	for i := range 10 {
+		switch i % 3 {
+		case 0:
+			chain.Next(&rpctest.Tx{From: other, To: account, Value: rpctest.Wei(int64(1000 * (i + 1)))})
+		case 1:
+			chain.Next(&rpctest.Tx{From: account, To: other, Value: rpctest.Wei(int64(10 * i)), L1Fee: rpctest.Wei(3)})
+		default:
+			chain.Next()
+		}
+	}
+
+	return chain
+}
+
+func TestIndexRange(t *testing.T) {
+	chain := scriptedChain()
+	a, node, store := setup(t, chain)
+	ctx := context.Background()
+
+	cfg := config.IndexerConfig{Mode: config.IndexModeRange, StartBlock: 1_000, EndBlock: 1_009, Concurrency: 3}
+
+	if err := a.indexRange(ctx, cfg, accounts); err != nil {
+		t.Fatalf("indexRange: %v", err)
+	}
+
+	checkpoint, found, err := store.GetCheckpoint(ctx, cfg.JobName())
+	if err != nil || !found || checkpoint != 1_009 {
+		t.Errorf("checkpoint = %d, %t, %v, want 1009", checkpoint, found, err)
+	}
+	indexertest.AssertBalance(t, chain, store, account)
+
+	ranges, err := store.GetMissingBlockRanges(ctx, account, cfg.StartBlock, cfg.EndBlock)
+	if err != nil || len(ranges) != 0 {
+		t.Errorf("missing ranges = %v, %v, want none", ranges, err)
+	}
+
+	// The second run resumes from the checkpoint, which is the end of the range
+	fetched := node.Calls("eth_getBlockByNumber")
+	if err := a.indexRange(ctx, cfg, accounts); err != nil {
+		t.Fatalf("indexRange again: %v", err)
+	}
+	if calls := node.Calls("eth_getBlockByNumber"); calls != fetched {
+		t.Errorf("second run fetched %d blocks, want none", calls-fetched)
+	}
+}
+
+func TestIndexRangeStopsAtFirstFailure(t *testing.T) {
+	chain := scriptedChain()
+	a, node, store := setup(t, chain)
+	ctx := context.Background()
+
+	cfg := config.IndexerConfig{Mode: config.IndexModeRange, StartBlock: 1_000, EndBlock: 1_009, Concurrency: 1}
+
+	// The first block with transactions of the account can't get its receipts
+	node.Fail("eth_getBlockReceipts", 3)
+	if err := a.indexRange(ctx, cfg, accounts); err == nil {
+		t.Fatal("indexRange succeeded with a block missing")
+	}
+	if _, found, _ := store.GetCheckpoint(ctx, cfg.JobName()); found {
+		t.Error("checkpoint moved past a block that failed")
+	}
+
+	if err := a.indexRange(ctx, cfg, accounts); err != nil {
+		t.Fatalf("indexRange after the node recovered: %v", err)
+	}
+	indexertest.AssertBalance(t, chain, store, account)
+}
+
+func TestIndexBlocks(t *testing.T) {
+	chain := scriptedChain()
+	a, _, store := setup(t, chain)
+	ctx := context.Background()
+
+	// Duplicated and beyond the head, both skipped
+	if err := a.indexBlocks(ctx, []uint64{1_003, 1_000, 1_003, 2_000}, 2, accounts); err != nil {
+		t.Fatalf("indexBlocks: %v", err)
+	}
+
+	for _, number := range []uint64{1_000, 1_003} {
+		if _, found, _ := store.GetBlock(ctx, number); !found {
+			t.Errorf("block %d wasn't stored", number)
+		}
+	}
+	if last, _, _ := store.GetLastIndexedBlock(ctx); last != 1_003 {
+		t.Errorf("last indexed block = %d, want 1003", last)
+	}
+
+	page, err := store.GetTransactionsFromAddress(ctx, account, database.TransactionFilter{})
+	if err != nil {
+		t.Fatalf("listing transactions: %v", err)
+	}
+	// Two transfers received, their fees are paid by the sender
+	if len(page.Transactions) != 2 {
+		t.Errorf("got %d transactions, want 2", len(page.Transactions))
+	}
+}
+
+func TestFollowReorg(t *testing.T) {
+	chain := rpctest.NewChain(1, start)
+	chain.Genesis[account] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Next(&rpctest.Tx{From: other, To: account, Value: rpctest.Wei(1000)})
+	chain.Empty(3)
+	// Orphaned by the reorg below
+	orphan := &rpctest.Tx{From: other, To: account, Value: rpctest.Wei(5000)}
+	chain.Next(orphan)
+	chain.Empty(1)
+
+	a, node, store := setup(t, chain)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.IndexerConfig{
+		Mode:           config.IndexModeFollow,
+		StartBlock:     1,
+		PollInterval:   5 * time.Millisecond,
+		Concurrency:    2,
+		MaxReorgDepth:  10,
+		Workers:        1,
+		JobMaxAttempts: 3,
+		JobStaleAfter:  time.Minute,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.follow(ctx, cfg, accounts)
+	}()
+
+	waitForCheckpoint(t, store, cfg.JobName(), chain.Head())
+
+	// Blocks 5 and 6 get replaced, and the new chain grows past them
+	fork := chain.Fork(5, "fork")
+	fork.Next(&rpctest.Tx{From: account, To: other, Value: rpctest.Wei(200)})
+	fork.Empty(2)
+	node.SetChain(fork)
+
+	waitForCheckpoint(t, store, cfg.JobName(), fork.Head())
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+
+	for number := uint64(1); number <= fork.Head(); number++ {
+		stored, found, err := store.GetBlock(context.Background(), number)
+		if err != nil || !found {
+			t.Fatalf("block %d: found %t, %v", number, found, err)
+		}
+		if stored.Hash != fork.Block(number).Hash {
+			t.Errorf("block %d has hash %s, canonical is %s", number, stored.Hash, fork.Block(number).Hash)
+		}
+	}
+
+	page, err := store.GetTransactionsFromAddress(context.Background(), account, database.TransactionFilter{})
+	if err != nil {
+		t.Fatalf("listing transactions: %v", err)
+	}
+	for _, tx := range page.Transactions {
+		if tx.Hash == orphan.Hash {
+			t.Errorf("orphaned transaction %s is still stored", orphan.Hash)
+		}
+	}
+	indexertest.AssertBalance(t, fork, store, account)
+}
+
+func waitForCheckpoint(t *testing.T, store database.Store, job string, block uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		checkpoint, found, err := store.GetCheckpoint(context.Background(), job)
+		if err != nil {
+			t.Fatalf("getting checkpoint: %v", err)
+		}
+		if found && checkpoint == block {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s didn't reach block %d in time", job, block)
+}
+
+func TestFollowStart(t *testing.T) {
+	tests := []struct {
+		name string
+		// Blocks swept under the job before following, if any
+		job        string
+		from, to   uint64
+		startBlock uint64
+		want       uint64
+	}{
+		{name: "nothing indexed", want: 1_009 - 2},
+		{name: "nothing indexed with a start block", startBlock: 1_003, want: 1_003},
+		{name: "checkpoint", job: "follow", from: 1_000, to: 1_005, want: 1_006},
+		// A restart with START_BLOCK still set neither fetches everything again nor leaves a gap
+		{name: "start block before the checkpoint", job: "follow", from: 1_000, to: 1_005, startBlock: 1_002, want: 1_006},
+		{name: "start block after the checkpoint", job: "follow", from: 1_000, to: 1_005, startBlock: 1_008, want: 1_006},
+		{name: "only indexed by another job", job: "range", from: 1_000, to: 1_004, want: 1_005},
+		{name: "only indexed by another job with a start block", job: "range", from: 1_000, to: 1_004, startBlock: 1_002, want: 1_005},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _, _ := setup(t, scriptedChain())
+			ctx := context.Background()
+
+			if tt.job != "" {
+				if err := a.sweep(ctx, tt.job, tt.from, tt.to, 1, accounts, nil); err != nil {
+					t.Fatalf("sweep: %v", err)
+				}
+			}
+
+			cfg := config.IndexerConfig{Mode: config.IndexModeFollow, StartBlock: tt.startBlock, Confirmations: 2}
+			got, err := a.followStart(ctx, cfg.JobName(), cfg)
+			if err != nil {
+				t.Fatalf("followStart: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("followStart = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowTrackedAddress(t *testing.T) {
+	tracked := rpctest.Address(5)
+
+	chain := rpctest.NewChain(1, start)
+	chain.Genesis[tracked] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Next(&rpctest.Tx{From: other, To: tracked, Value: rpctest.Wei(1000)})
+	chain.Next(&rpctest.Tx{From: tracked, To: account, Value: rpctest.Wei(300), L1Fee: rpctest.Wei(7)})
+	chain.Empty(2)
+
+	a, node, store := setup(t, chain)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.IndexerConfig{
+		Mode:           config.IndexModeFollow,
+		StartBlock:     1,
+		PollInterval:   5 * time.Millisecond,
+		Concurrency:    2,
+		MaxReorgDepth:  10,
+		Workers:        1,
+		JobMaxAttempts: 3,
+		JobStaleAfter:  time.Minute,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.follow(ctx, cfg, accounts)
+	}()
+
+	waitForCheckpoint(t, store, cfg.JobName(), chain.Head())
+
+	// Registered while following, with history from the first block
+	startBlock := uint64(1)
+	registered, added, err := store.AddTrackedAddress(ctx, tracked, &startBlock)
+	if err != nil || !added {
+		t.Fatalf("tracking address: %t, %v", added, err)
+	}
+
+	grown := chain.Fork(chain.Head()+1, "grown")
+	grown.Next(&rpctest.Tx{From: other, To: tracked, Value: rpctest.Wei(50)})
+	grown.Empty(1)
+	node.SetChain(grown)
+
+	waitForCheckpoint(t, store, cfg.JobName(), grown.Head())
+	job := waitForJob(t, store, *registered.BackfillJob)
+
+	if job.Status != database.JobDone || job.ToBlock == nil || *job.ToBlock != chain.Head() {
+		t.Errorf("backfill job = %+v, want done up to block %d", job, chain.Head())
+	}
+	if job.Progress != chain.Head() {
+		t.Errorf("backfill progress = %d, want %d", job.Progress, chain.Head())
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+
+	want := new(big.Int).Sub(grown.Balance(tracked, grown.Head()), grown.Balance(tracked, 0))
+	got, err := store.GetBalance(context.Background(), tracked)
+	if err != nil {
+		t.Fatalf("getting balance: %v", err)
+	}
+	if !got.Balance.Equal(decimal.NewFromBigInt(want, 0)) {
+		t.Errorf("balance = %s, node says %s", got.Balance, want)
+	}
+}
+
+func TestJobsRetryAndFail(t *testing.T) {
+	chain := scriptedChain()
+	a, node, store := setup(t, chain)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.IndexerConfig{
+		Mode:           config.IndexModeJobs,
+		PollInterval:   5 * time.Millisecond,
+		Concurrency:    1,
+		Workers:        2,
+		JobMaxAttempts: 2,
+		JobStaleAfter:  time.Minute,
+	}
+
+	// Beyond the head of the chain, every attempt fails
+	missing := uint64(2_000)
+	failing, err := store.EnqueueJob(ctx, database.Job{Kind: database.JobKindReindex, FromBlock: missing, ToBlock: &missing})
+	if err != nil {
+		t.Fatalf("enqueueing job: %v", err)
+	}
+
+	// The first attempt fails on the receipts, the retry goes through
+	node.Fail("eth_getBlockReceipts", 3)
+	end := uint64(1_009)
+	reindex, err := store.EnqueueJob(ctx, database.Job{Kind: database.JobKindReindex, FromBlock: 1_000, ToBlock: &end})
+	if err != nil {
+		t.Fatalf("enqueueing job: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.runWorkers(ctx, cfg, accounts)
+	}()
+
+	failed := waitForJob(t, store, failing.ID)
+	if failed.Status != database.JobFailed || failed.Attempts != 2 || failed.Error == nil {
+		t.Errorf("failing job = %+v, want failed after 2 attempts", failed)
+	}
+
+	job := waitForJob(t, store, reindex.ID)
+	if job.Status != database.JobDone || job.Attempts != 1 || job.Progress != 10 {
+		t.Errorf("reindex job = %+v, want done with 10 blocks after 1 failed attempt", job)
+	}
+	indexertest.AssertBalance(t, chain, store, account)
+
+	cancel()
+	<-done
+}
+
+func TestBackfillOfBlocksIndexedForOtherAddresses(t *testing.T) {
+	tracked := rpctest.Address(5)
+
+	// The account calls the tracked contract, which pays other, and then another contract
+	// paying the account back
+	contract := rpctest.Address(6)
+	chain := rpctest.NewChain(1, start)
+	chain.Genesis[account] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Genesis[tracked] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Genesis[contract] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Next(&rpctest.Tx{From: account, To: tracked, Input: "0xabcdef", Calls: []rpc.CallTrace{
+		{From: tracked, To: other, Value: "0x10", Input: "0x"},
+		{From: tracked, To: contract, Value: "0x0", Input: "0x1234", Calls: []rpc.CallTrace{
+			{From: contract, To: account, Value: "0x20", Input: "0x"},
+		}},
+	}})
+	chain.Empty(1)
+
+	a, _, store := setup(t, chain)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Followed for the account, then the tracked address is backfilled over the same blocks
+	if err := a.sweep(ctx, "follow", 1, chain.Head(), 1, accounts, nil); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	end := chain.Head()
+	backfill, err := store.EnqueueJob(ctx, database.Job{Kind: database.JobKindBackfill, Address: &tracked, FromBlock: 1, ToBlock: &end})
+	if err != nil {
+		t.Fatalf("enqueueing job: %v", err)
+	}
+
+	cfg := config.IndexerConfig{Mode: config.IndexModeJobs, PollInterval: 5 * time.Millisecond, Concurrency: 1, Workers: 1, JobMaxAttempts: 1, JobStaleAfter: time.Minute}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.runWorkers(ctx, cfg, accounts)
+	}()
+
+	if job := waitForJob(t, store, backfill.ID); job.Status != database.JobDone {
+		t.Fatalf("backfill job = %+v, want done", job)
+	}
+	cancel()
+	<-done
+
+	indexertest.AssertBalance(t, chain, store, account)
+	indexertest.AssertBalance(t, chain, store, tracked)
+
+	// The backfill only counts as indexed for the tracked address
+	if ranges, err := store.GetMissingBlockRanges(context.Background(), other, 1, chain.Head()); err != nil || len(ranges) != 1 {
+		t.Errorf("missing ranges of other = %+v, %v, want every block", ranges, err)
+	}
+}
+
+// waitForJob waits until the job is done or failed.
+func waitForJob(t *testing.T, store database.Store, id int64) database.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, found, err := store.GetJob(context.Background(), id)
+		if err != nil || !found {
+			t.Fatalf("getting job %d: %t, %v", id, found, err)
+		}
+		if job.Status == database.JobDone || job.Status == database.JobFailed {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("job %d didn't finish in time", id)
+	return database.Job{}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+)
+
+// trackedAccounts adds the addresses registered through the API to the configured ones.
+func (a *app) trackedAccounts(ctx context.Context, configured map[string]bool) (map[string]bool, error) {
+	tracked, err := a.dbClient.GetTrackedAddresses(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tracked addresses: %w", err)
+	}
+
+	accounts := maps.Clone(configured)
+	for _, t := range tracked {
+		accounts[t.Address] = true
+	}
+
+	return accounts, nil
+}
+
+// followAccounts merges the addresses registered through the API into the configured ones.
+// The follower indexes new addresses from next on, so their backfill ends at the block before it.
+// Backfills are scheduled before reading the addresses: one registered in between is indexed by
+// the follower a bit earlier than its backfill ends, but no block is left out.
+func (a *app) followAccounts(ctx context.Context, configured map[string]bool, next uint64) (map[string]bool, error) {
+	if next > 0 {
+		scheduled, err := a.dbClient.ScheduleBackfills(ctx, next-1)
+		if err != nil {
+			return nil, fmt.Errorf("error scheduling backfills: %w", err)
+		}
+		for _, job := range scheduled {
+			log.Printf("Following %s from block %d, job %d backfills blocks %d to %d", *job.Address, next, job.ID, job.FromBlock, next-1)
+		}
+	}
+
+	return a.trackedAccounts(ctx, configured)
+}
+
+// runWorkers runs cfg.Workers workers until the context is cancelled.
+func (a *app) runWorkers(ctx context.Context, cfg config.IndexerConfig, configured map[string]bool) {
+	var wg sync.WaitGroup
+	for i := range cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.work(ctx, cfg, configured, i)
+		}()
+	}
+	wg.Wait()
+}
+
+// work claims jobs from the queue and runs them one at a time, polling for new ones every
+// cfg.PollInterval when the queue is empty.
+func (a *app) work(ctx context.Context, cfg config.IndexerConfig, configured map[string]bool, worker int) {
+	for {
+		job, found, err := a.dbClient.ClaimJob(ctx, cfg.JobStaleAfter)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Worker %d: error claiming a job: %v", worker, err)
+		}
+		if found {
+			a.runJob(ctx, cfg, configured, worker, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+// runJob runs a claimed job and records how it ended.
+func (a *app) runJob(ctx context.Context, cfg config.IndexerConfig, configured map[string]bool, worker int, job database.Job) {
+	log.Printf("Worker %d: running %s job %d, blocks %d to %d", worker, job.Kind, job.ID, job.FromBlock, *job.ToBlock)
+
+	// A block can take longer than JobStaleAfter, e.g. while the node is failing, so the job
+	// has to show it's alive without making progress
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	go a.heartbeat(heartbeatCtx, job.ID, cfg.JobStaleAfter/3)
+
+	err := a.runJobBlocks(ctx, cfg, configured, job)
+	stopHeartbeat()
+
+	// Shutting down, another worker resumes the job from its checkpoint
+	if ctx.Err() != nil {
+		if err := a.dbClient.ReleaseJob(context.WithoutCancel(ctx), job.ID); err != nil {
+			log.Printf("Worker %d: error releasing job %d: %v", worker, job.ID, err)
+		}
+		return
+	}
+
+	if err != nil {
+		status, failErr := a.dbClient.FailJob(ctx, job.ID, err.Error(), cfg.JobMaxAttempts, cfg.JobRetryDelay)
+		if failErr != nil {
+			log.Printf("Worker %d: error recording the failure of job %d: %v", worker, job.ID, failErr)
+			return
+		}
+		log.Printf("Worker %d: job %d failed, now %s: %v", worker, job.ID, status, err)
+		return
+	}
+
+	if err := a.dbClient.CompleteJob(ctx, job.ID); err != nil {
+		log.Printf("Worker %d: error completing job %d: %v", worker, job.ID, err)
+		return
+	}
+	log.Printf("Worker %d: finished job %d", worker, job.ID)
+}
+
+// heartbeat keeps the running job from being claimed again by another worker, until the
+// context is cancelled.
+func (a *app) heartbeat(ctx context.Context, id int64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.dbClient.HeartbeatJob(ctx, id); err != nil && ctx.Err() == nil {
+				log.Printf("Error sending the heartbeat of job %d: %v", id, err)
+			}
+		}
+	}
+}
+
+// runJobBlocks indexes the blocks of the job under its own checkpoint, so a retry resumes where
+// the previous attempt stopped. A job without an address covers every indexed address.
+func (a *app) runJobBlocks(ctx context.Context, cfg config.IndexerConfig, configured map[string]bool, job database.Job) error {
+	// The follower picked the address up before its start block, nothing to backfill
+	if job.FromBlock > *job.ToBlock {
+		return nil
+	}
+
+	accounts := map[string]bool{}
+	if job.Address != nil {
+		accounts[*job.Address] = true
+	} else {
+		var err error
+		if accounts, err = a.trackedAccounts(ctx, configured); err != nil {
+			return err
+		}
+	}
+
+	return a.sweep(ctx, database.JobCheckpoint(job.ID), job.FromBlock, *job.ToBlock, cfg.Concurrency, accounts, func(done uint64) {
+		if err := a.dbClient.UpdateJobProgress(ctx, job.ID, done); err != nil {
+			log.Printf("Error updating the progress of job %d: %v", job.ID, err)
+		}
+	})
+}
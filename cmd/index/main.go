@@ -4,20 +4,33 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"slices"
 	"strings"
-	"time"
-
-	"github.com/shopspring/decimal"
+	"syscall"
 
 	"github.com/danilevy1212/baseidx-wt/internal/config"
 	"github.com/danilevy1212/baseidx-wt/internal/data"
 	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer"
 	"github.com/danilevy1212/baseidx-wt/internal/rpc"
 )
 
-var rpcClient rpc.Client
-var dbClient *database.DBClient
+// app holds the clients shared by every mode of the indexer.
+type app struct {
+	rpcClient    *rpc.Client
+	dbClient     database.Store
+	blockIndexer *indexer.Indexer
+}
+
+func newApp(rpcClient *rpc.Client, dbClient database.Store) *app {
+	return &app{
+		rpcClient:    rpcClient,
+		dbClient:     dbClient,
+		blockIndexer: indexer.New(rpcClient, dbClient),
+	}
+}
 
 func main() {
 	ctx := context.Background()
@@ -26,12 +39,13 @@ func main() {
 	if err != nil {
 		log.Fatal("Error parsing config", err)
 	}
-	log.Printf("Config: %+v", cfg)
+	log.Printf("Config: %s", cfg)
 
-	dbClient, err = database.New(ctx, cfg.Database)
+	dbClient, err := database.New(ctx, cfg.Database)
 	if err != nil {
 		log.Fatal("Error creating database client", err)
 	}
+	defer dbClient.Close()
 
 	if err = dbClient.Ping(ctx); err != nil {
 		log.Fatal("Error pinging database", err)
@@ -39,292 +53,95 @@ func main() {
 
 	log.Println("Database connection successful")
 
-	rpcClient = rpc.NewClient(cfg.BaseAPI.BaseURL, cfg.BaseAPI.BaseDebugURL)
-
-	lastBlock, err := rpcClient.GetLastestBlock()
+	rpcClient, err := rpc.NewClient(cfg.BaseAPI)
 	if err != nil {
-		log.Fatal("Error getting latest block", err)
+		log.Fatal("Error creating rpc client", err)
 	}
-	log.Printf("last block: %v", lastBlock.Result)
 
-	lastBlockIdx, err := data.NewHexFromString(lastBlock.Result)
-	if err != nil {
-		log.Fatal("Error parsing last block index", err)
-	}
-
-	cfg.Blocks = deduplicate(cfg.Blocks)
-	slices.Sort(cfg.Blocks)
+	a := newApp(rpcClient, dbClient)
 
 	accounts := map[string]bool{}
 	for _, addr := range cfg.Addresses {
 		accounts[strings.ToLower(addr)] = true
 	}
 
-	log.Printf("Accounts to index: %v", accounts)
-
-	for _, blockIdx := range cfg.Blocks {
-		if blockIdx > lastBlockIdx.Uint64() {
-			log.Printf("Skipping block %d, it is greater than the latest block %d", blockIdx, lastBlockIdx.Uint64())
-			break
-		}
-
-		transactions, err := processBlock(*data.NewHexFromUint64(blockIdx), accounts)
+	// The follower refreshes the addresses registered through the API on every poll, and
+	// jobs read them when they start
+	if cfg.Indexer.Mode == config.IndexModeBlocks || cfg.Indexer.Mode == config.IndexModeRange {
+		accounts, err = a.trackedAccounts(ctx, accounts)
 		if err != nil {
-			log.Printf("Error processing block %d: %v", blockIdx, err)
-			continue
+			log.Fatal("Error loading tracked addresses", err)
 		}
-
-		// Bulk update transactions
-		log.Printf("Processed block %d with %d transactions", blockIdx, len(transactions))
-
-		if err := dbClient.UpsertTransactions(ctx, transactions); err != nil {
-			log.Printf("Error upserting transactions for block %d: %v", blockIdx, err)
-			continue
-		}
-	}
-}
-
-// TODO  Bring this to it's own service later, so I can re-use it in the API
-func processBlock(blockIdx data.Hex, accounts map[string]bool) ([]database.Transaction, error) {
-	blockDTO, err := rpcClient.GetBlockByNumber(blockIdx, true)
-	if err != nil {
-		log.Printf("Error getting block %s: %v", blockIdx.String(), err)
-		return nil, err
 	}
-	blockTimestampHex, err := data.NewHexFromString(blockDTO.Result.Timestamp)
-	if err != nil {
-		log.Printf("Error parsing block timestamp %s: %v", blockDTO.Result.Timestamp, err)
-		return nil, err
-	}
-
-	blockTimestamp := time.Unix(blockTimestampHex.Int64(), 0)
-
-	var receiptsDTO *rpc.BlockReceiptsDTO
-	transactions := []database.Transaction{}
-
-	log.Printf("Processing block %s at index %d, at timestamp %s", blockDTO.Result.Number, blockIdx.Uint64(), blockDTO.Result.Timestamp)
-
-	// Go through the transactions
-	for _, txDto := range blockDTO.Result.Transactions {
-		// Irrelevant transaction
-		if !accounts[txDto.From] && !accounts[txDto.To] {
-			continue
-		}
-
-		var receiptDTO *rpc.Receipt
-		// Get the receipts if we haven't already
-		if receiptsDTO == nil {
-			receiptsDTO, err = rpcClient.GetBlockReceipts(blockIdx)
-
-			if err != nil {
-				log.Printf("Error getting receipts for block %s: %v", blockIdx.String(), err)
-				continue
-			}
-		}
-
-		// Get the receipt for the matching TX
-		for _, r := range receiptsDTO.Result {
-			if r.TransactionHash == txDto.Hash {
-				receiptDTO = &r
-				break
-			}
-		}
-
-		if receiptDTO == nil {
-			log.Printf("No receipt found for transaction %s in block %s", txDto.Hash, blockIdx.String())
-			continue
-		}
-
-		log.Printf("Processing transaction %s from %s to %s with value %s at block index %s", txDto.Hash, txDto.From, txDto.To, txDto.Value, blockIdx.String())
-
-		var trx database.Transaction
-
-		trx.Timestamp = blockTimestamp
-		trx.BlockIndex = blockDTO.Result.Number
-
-		trx.Hash = txDto.Hash
-		trx.To = txDto.To
-		trx.From = txDto.From
-
-		trx.Type = "transfer"
-		if txDto.Input != "0x" {
-			trx.Type = "call"
-		}
 
-		if receiptDTO.Status == "0x1" {
-			trx.Succesful = true
-		}
-
-		amount, err := data.NewHexFromString(txDto.Value)
-		if err != nil {
-			log.Printf("Error parsing transaction value %s: %v", txDto.Value, err)
-			continue
-		}
-
-		trx.Value = decimal.NewFromBigInt(amount.Int, 0)
-
-		log.Printf("Transaction details: %+v", trx)
-
-		transactions = append(transactions, trx)
-
-		// Recursively add calls
-		if trx.Type == "call" {
-			err := processContractCall(trx, accounts, &transactions)
-			if err != nil {
-				log.Printf("Error processing contract call for transaction %s: %v", trx.Hash, err)
-				continue
-			}
-		}
+	log.Printf("Accounts to index: %v", accounts)
 
-		// Fee
-		var fee database.Transaction
-		fee.Hash = trx.Hash + "_fee"
-		fee.Type = "fee"
-		fee.From = trx.From
-		fee.To = trx.From // This is not really used, but it is a valid address
-		fee.BlockIndex = trx.BlockIndex
-		fee.Timestamp = trx.Timestamp
-		fee.Succesful = true // Fees are always successful
+	// Stop in-flight RPC calls on shutdown instead of waiting for them
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		l1Fee := decimal.Zero
-		if receiptDTO.L1Fee != nil {
-			l1FeeHex, err := data.NewHexFromString(*receiptDTO.L1Fee)
-			if err != nil {
-				log.Printf("Error parsing L1 fee %s: %v", *receiptDTO.L1Fee, err)
-				continue
-			}
-			l1Fee = decimal.NewFromBigInt(l1FeeHex.Int, 0)
+	switch cfg.Indexer.Mode {
+	case config.IndexModeFollow:
+		if err := a.follow(ctx, cfg.Indexer, accounts); err != nil {
+			log.Fatal("Error following the chain: ", err)
 		}
-		effectiveGasPriceHex, err := data.NewHexFromString(receiptDTO.EffectiveGasPrice)
-		if err != nil {
-			log.Printf("Error parsing effective gas price %s: %v", receiptDTO.EffectiveGasPrice, err)
-			continue
+	case config.IndexModeRange:
+		if err := a.indexRange(ctx, cfg.Indexer, accounts); err != nil {
+			log.Fatal("Error indexing range: ", err)
 		}
-		effectiveGasPrice := decimal.NewFromBigInt(effectiveGasPriceHex.Int, 0)
-
-		gasUsedHex, err := data.NewHexFromString(receiptDTO.GasUsed)
-		if err != nil {
-			log.Printf("Error parsing gas used %s: %v", receiptDTO.GasUsed, err)
-			continue
+	case config.IndexModeJobs:
+		log.Printf("Running jobs with %d workers", cfg.Indexer.Workers)
+		a.runWorkers(ctx, cfg.Indexer, accounts)
+	default:
+		if err := a.indexBlocks(ctx, cfg.Blocks, cfg.Indexer.Concurrency, accounts); err != nil {
+			log.Fatal("Error indexing blocks: ", err)
 		}
-		gasUsed := decimal.NewFromBigInt(gasUsedHex.Int, 0)
-
-		fee.Value = effectiveGasPrice.Mul(gasUsed).Add(l1Fee)
-
-		log.Printf("Fee details: %+v", fee)
-
-		transactions = append(transactions, fee)
 	}
-
-	return transactions, nil
 }
 
-func processContractCall(origin database.Transaction, accounts map[string]bool, transactions *[]database.Transaction) error {
-	calls, err := rpcClient.GetTransactionCallTrace(origin.Hash)
+// indexBlocks processes the fixed list of blocks once.
+func (a *app) indexBlocks(ctx context.Context, blocks []uint64, concurrency int, accounts map[string]bool) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("no BLOCKS configured")
+	}
 
+	lastBlockIdx, err := a.getLatestBlock(ctx)
 	if err != nil {
-		log.Printf("Error getting call trace for transaction %s: %v", origin.Hash, err)
 		return err
 	}
+	log.Printf("last block: %d", lastBlockIdx)
 
-	return recurseCallStack(origin, calls.Result.Calls, accounts, transactions, new(int))
-}
-
-func recurseCallStack(origin database.Transaction, callStack []rpc.CallTrace, accounts map[string]bool, transactions *[]database.Transaction, count *int) error {
-	for _, call := range callStack {
-		// Skip if no value was transferred
-		if call.Value == "0x0" || call.Value == "0x" || call.Value == "" {
-			// Still recurse to deeper calls even if this call itself had no value
-			if len(call.Calls) > 0 {
-				err := recurseCallStack(origin, call.Calls, accounts, transactions, count)
-				if err != nil {
-					return err
-				}
-			}
-			continue
-		}
+	slices.Sort(blocks)
+	blocks = slices.Compact(blocks)
 
-		// Parse value
-		valHex, err := data.NewHexFromString(call.Value)
-		if err != nil {
-			log.Printf("Error parsing internal call value %s: %v", call.Value, err)
-			continue
-		}
+	if i := slices.IndexFunc(blocks, func(blockIdx uint64) bool { return blockIdx > lastBlockIdx }); i >= 0 {
+		log.Printf("Skipping blocks from %d, they are greater than the latest block %d", blocks[i], lastBlockIdx)
+		blocks = blocks[:i]
+	}
 
-		// Only include if from or to is in accounts map
-		if !accounts[call.From] && !accounts[call.To] {
-			// Still recurse
-			if len(call.Calls) > 0 {
-				err := recurseCallStack(origin, call.Calls, accounts, transactions, count)
-				if err != nil {
-					return err
-				}
-			}
+	for fetched := range a.blockIndexer.FetchBlocks(ctx, slices.Values(blocks), concurrency, accounts) {
+		if fetched.Err != nil {
+			log.Printf("Error processing block %d: %v", fetched.Number, fetched.Err)
 			continue
 		}
 
-		// Increment the count for unique internal calls
-		*count++
-
-		trx := database.Transaction{
-			From:       call.From,
-			To:         call.To,
-			Hash:       origin.Hash + "_internal_" + fmt.Sprintf("%d", *count),
-			Value:      decimal.NewFromBigInt(valHex.Int, 0),
-			BlockIndex: origin.BlockIndex,
-			Timestamp:  origin.Timestamp,
-			Succesful:  true,
-		}
-
-		trx.Type = "transfer"
-		if call.Input != "0x" {
-			trx.Type = "call"
-		}
-
-		log.Printf("Processing internal call %d: %+v", *count, trx)
-
-		*transactions = append(*transactions, trx)
-
-		// Recurse
-		if len(call.Calls) > 0 {
-			err := recurseCallStack(origin, call.Calls, accounts, transactions, count)
-			if err != nil {
-				return err
-			}
-		}
+		// Errors are already logged, move on to the next block
+		_ = a.blockIndexer.Commit(ctx, "", fetched.Indexed)
 	}
+
 	return nil
 }
 
-// TODO  Same, aka, move to utils or something
-func deduplicate[T comparable](original []T) []T {
-	unique := make([]T, 0, len(original))
-	seen := make(map[T]struct{})
+func (a *app) getLatestBlock(ctx context.Context) (uint64, error) {
+	lastBlock, err := a.rpcClient.GetLastestBlock(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error getting latest block: %w", err)
+	}
 
-	for _, item := range original {
-		if _, exists := seen[item]; !exists {
-			seen[item] = struct{}{}
-			unique = append(unique, item)
-		}
+	lastBlockIdx, err := data.NewHexFromString(lastBlock.Result)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing last block index: %w", err)
 	}
 
-	return unique
+	return lastBlockIdx.Uint64(), nil
 }
-
-// TODO  This could be interesting, but only if I have time for a "range sweep" endpoint
-// func scrapBlockChain(startIdx, endIdx data.Hex) error {
-// 	currentIdx := startIdx
-//
-// 	log.Printf("Starting to scrap blockchain from %d to %d", startIdx.Uint64(), endIdx.Uint64())
-// 	totalBlocks := new(big.Int).Sub(endIdx.Int, startIdx.Int)
-//
-// 	log.Printf("Total blocks to process: %d", totalBlocks.Uint64())
-//
-// 	for currentIdx.Uint64() != endIdx.Uint64() {
-// 		if err := processBlock(currentIdx); err != nil {
-// 			return err
-// 		}
-// 	}
-//
-// 	return nil
-// }
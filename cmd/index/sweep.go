@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer"
+)
+
+// indexRange walks every block between START_BLOCK and END_BLOCK (inclusive) through ProcessBlock,
+// resuming from the checkpoint of a previous run of the same range.
+func (a *app) indexRange(ctx context.Context, cfg config.IndexerConfig, accounts map[string]bool) error {
+	job := cfg.JobName()
+	startIdx, endIdx := cfg.StartBlock, cfg.EndBlock
+
+	lastBlockIdx, err := a.getLatestBlock(ctx)
+	if err != nil {
+		return err
+	}
+
+	if endIdx > lastBlockIdx {
+		return fmt.Errorf("end block %d is greater than the latest block %d", endIdx, lastBlockIdx)
+	}
+
+	return a.sweep(ctx, job, startIdx, endIdx, cfg.Concurrency, accounts, nil)
+}
+
+// sweep indexes every block between startIdx and endIdx (inclusive) for the accounts, moving
+// the checkpoint of the job along and resuming from it if a previous run was interrupted.
+// If progress isn't nil, it gets the number of blocks swept after every block.
+func (a *app) sweep(ctx context.Context, job string, startIdx, endIdx uint64, concurrency int, accounts map[string]bool, progress func(done uint64)) error {
+	checkpoint, found, err := a.dbClient.GetCheckpoint(ctx, job)
+	if err != nil {
+		return fmt.Errorf("error getting checkpoint for job %s: %w", job, err)
+	}
+
+	firstIdx := startIdx
+	if found && checkpoint >= startIdx {
+		if checkpoint >= endIdx {
+			log.Printf("Job %s already swept blocks %d to %d, nothing to do", job, startIdx, endIdx)
+			return nil
+		}
+
+		firstIdx = checkpoint + 1
+		log.Printf("Resuming job %s from block %d", job, firstIdx)
+	}
+
+	totalBlocks := endIdx - startIdx + 1
+	log.Printf("Sweeping blocks %d to %d, %d blocks in total", firstIdx, endIdx, totalBlocks)
+
+	for fetched := range a.blockIndexer.FetchBlocks(ctx, indexer.BlockRange(firstIdx, endIdx), concurrency, accounts) {
+		// The checkpoint only covers contiguous blocks, so stop at the first failure and let
+		// the next run resume from it
+		if fetched.Err != nil {
+			return fmt.Errorf("error processing block %d: %w", fetched.Number, fetched.Err)
+		}
+
+		if err := a.blockIndexer.Commit(ctx, job, fetched.Indexed); err != nil {
+			return fmt.Errorf("error storing block %d: %w", fetched.Number, err)
+		}
+
+		done := fetched.Number - startIdx + 1
+		if progress != nil {
+			progress(done)
+		}
+		if done%100 == 0 {
+			log.Printf("Swept %d/%d blocks", done, totalBlocks)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("Sweep of job %s interrupted", job)
+		return err
+	}
+
+	log.Printf("Finished sweeping blocks %d to %d", startIdx, endIdx)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+)
+
+const usage = "usage: migrate [up | down [steps] | status]"
+
+func main() {
+	ctx := context.Background()
+
+	command := "up"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	steps := 1
+	if command == "down" && len(os.Args) > 2 {
+		n, err := strconv.Atoi(os.Args[2])
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid number of steps %q, %s", os.Args[2], usage)
+		}
+		steps = n
+	}
+
+	cfg, err := config.New(ctx)
+	if err != nil {
+		log.Fatal("Error parsing config", err)
+	}
+
+	dbClient, err := database.New(ctx, cfg.Database)
+	if err != nil {
+		log.Fatal("Error creating database client", err)
+	}
+	defer dbClient.Close()
+
+	switch command {
+	case "up":
+		applied, err := dbClient.Migrate(ctx)
+		if err != nil {
+			log.Fatal("Error applying migrations: ", err)
+		}
+		log.Printf("%d migrations applied", len(applied))
+	case "down":
+		reverted, err := dbClient.MigrateDown(ctx, steps)
+		if err != nil {
+			log.Fatal("Error reverting migrations: ", err)
+		}
+		log.Printf("%d migrations reverted", len(reverted))
+	case "status":
+		statuses, err := dbClient.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatal("Error getting migration status: ", err)
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil {
+				log.Printf("%04d_%s: pending", s.Version, s.Name)
+			} else {
+				log.Printf("%04d_%s: applied at %s", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05 MST"))
+			}
+		}
+	default:
+		log.Fatal(usage)
+	}
+}
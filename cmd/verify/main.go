@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+)
+
+// verify compares the balance the indexer derived for every configured and tracked address
+// with how much the balance changed on-chain since the address is indexed, up to the last
+// indexed block. Exits with status 1 if any of them differ.
+func main() {
+	ctx := context.Background()
+	cfg, err := config.New(ctx)
+
+	if err != nil {
+		log.Fatal("Error parsing config", err)
+	}
+
+	dbClient, err := database.New(ctx, cfg.Database)
+	if err != nil {
+		log.Fatal("Error creating database client", err)
+	}
+	defer dbClient.Close()
+
+	rpcClient, err := rpc.NewClient(cfg.BaseAPI)
+	if err != nil {
+		log.Fatal("Error creating rpc client", err)
+	}
+
+	lastBlock, found, err := dbClient.GetLastIndexedBlock(ctx)
+	if err != nil {
+		log.Fatal("Error getting last indexed block: ", err)
+	}
+	if !found {
+		log.Fatal("Nothing indexed yet, nothing to verify")
+	}
+
+	targets, err := verifyTargets(ctx, dbClient, cfg.Addresses)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Verifying balances at block %d", lastBlock)
+
+	discrepancies := 0
+	for _, target := range targets {
+		res, err := verifyTarget(ctx, dbClient, rpcClient, target, lastBlock)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if res.matches() {
+			log.Printf("OK       %s: %s wei since block %d (%d transactions)", target.address, res.indexed, target.from, res.transactions)
+		} else {
+			discrepancies++
+			log.Printf("MISMATCH %s: indexed %s wei since block %d (%d transactions), on-chain %s wei, difference %s wei",
+				target.address, res.indexed, target.from, res.transactions, res.onChain, res.onChain.Sub(res.indexed))
+		}
+
+		if cfg.Verify.MissingRanges {
+			for _, r := range res.missing {
+				log.Printf("         %s: missing blocks %d to %d (%d blocks)", target.address, r.From, r.To, r.To-r.From+1)
+			}
+		}
+		if res.backfill != nil {
+			log.Printf("         %s: backfill job %d is %s, the blocks before %d are not all indexed yet", target.address, res.backfill.ID, res.backfill.Status, target.from)
+		}
+	}
+
+	if discrepancies > 0 {
+		log.Printf("%d of %d addresses don't match, check for missing blocks with VERIFY_MISSING_RANGES=true", discrepancies, len(targets))
+		os.Exit(1)
+	}
+
+	log.Printf("All %d addresses match", len(targets))
+}
+
+// target is an address to verify, indexed from the block from on.
+type target struct {
+	address string
+	from    uint64
+	// Job backfilling a tracked address, nil for the configured ones
+	backfillJob *int64
+}
+
+// verifyTargets returns the configured and the tracked addresses, each one indexed since the
+// first block indexed for it. Addresses nothing was indexed for yet are skipped.
+func verifyTargets(ctx context.Context, store database.Store, configured []string) ([]target, error) {
+	tracked, err := store.GetTrackedAddresses(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tracked addresses: %w", err)
+	}
+
+	addresses := []string{}
+	backfillJobs := map[string]*int64{}
+	for _, addr := range configured {
+		addresses = append(addresses, strings.ToLower(addr))
+	}
+	for _, t := range tracked {
+		addresses = append(addresses, t.Address)
+		backfillJobs[t.Address] = t.BackfillJob
+	}
+
+	targets := []target{}
+	seen := map[string]bool{}
+	for _, addr := range addresses {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		first, found, err := store.GetFirstIndexedBlock(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("error getting first indexed block of %s: %w", addr, err)
+		}
+		if !found {
+			log.Printf("SKIPPED  %s: nothing indexed for it yet", addr)
+			continue
+		}
+		targets = append(targets, target{address: addr, from: first, backfillJob: backfillJobs[addr]})
+	}
+
+	return targets, nil
+}
+
+type verifyResult struct {
+	// Change of the indexed balance between the blocks before target.from and the last one
+	indexed      decimal.Decimal
+	transactions uint64
+	// Change of the balance on-chain between the blocks before target.from and the last one
+	onChain decimal.Decimal
+	// Gaps between target.from and the last indexed block
+	missing []database.BlockRange
+	// Backfill of a tracked address that isn't done yet
+	backfill *database.Job
+}
+
+func (r verifyResult) matches() bool {
+	return r.indexed.Equal(r.onChain)
+}
+
+// verifyTarget compares the change of the indexed balance of the address with the change of
+// its balance on-chain since it is indexed, so whatever it had before doesn't count as a mismatch.
+func verifyTarget(ctx context.Context, store database.Store, rpcClient *rpc.Client, t target, last uint64) (verifyResult, error) {
+	var res verifyResult
+
+	indexed, err := store.GetBalanceAsOf(ctx, t.address, database.BalanceAsOf{Block: &last})
+	if err != nil {
+		return res, fmt.Errorf("error getting indexed balance of %s: %w", t.address, err)
+	}
+	res.indexed = indexed.Balance
+	res.transactions = indexed.Transactions
+
+	// Transactions stored before the blocks indexed for it were recorded don't count either
+	if t.from > 0 {
+		previous := t.from - 1
+		before, err := store.GetBalanceAsOf(ctx, t.address, database.BalanceAsOf{Block: &previous})
+		if err != nil {
+			return res, fmt.Errorf("error getting indexed balance of %s: %w", t.address, err)
+		}
+		res.indexed = res.indexed.Sub(before.Balance)
+		res.transactions -= before.Transactions
+	}
+
+	after, err := onChainBalance(ctx, rpcClient, t.address, last)
+	if err != nil {
+		return res, err
+	}
+	before := decimal.Zero
+	if t.from > 0 {
+		if before, err = onChainBalance(ctx, rpcClient, t.address, t.from-1); err != nil {
+			return res, err
+		}
+	}
+	res.onChain = after.Sub(before)
+
+	res.missing, err = store.GetMissingBlockRanges(ctx, t.address, t.from, last)
+	if err != nil {
+		return res, fmt.Errorf("error getting missing block ranges of %s: %w", t.address, err)
+	}
+
+	if t.backfillJob != nil {
+		job, found, err := store.GetJob(ctx, *t.backfillJob)
+		if err != nil {
+			return res, fmt.Errorf("error getting backfill job of %s: %w", t.address, err)
+		}
+		if found && job.Status != database.JobDone {
+			res.backfill = &job
+		}
+	}
+
+	return res, nil
+}
+
+func onChainBalance(ctx context.Context, rpcClient *rpc.Client, address string, block uint64) (decimal.Decimal, error) {
+	dto, err := rpcClient.GetBalance(ctx, address, *data.NewHexFromUint64(block))
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("error getting on-chain balance of %s at block %d: %w", address, block, err)
+	}
+
+	balance, err := data.NewHexFromString(dto.Result)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("error parsing on-chain balance of %s at block %d: %w", address, block, err)
+	}
+
+	return decimal.NewFromBigInt(balance.Int, 0), nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer/indexertest"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+var (
+	account = rpctest.Address(1)
+	other   = rpctest.Address(2)
+	tracked = rpctest.Address(3)
+)
+
+// chain has activity of the account and the tracked address over blocks 1000 to 1005, and both
+// had a balance before.
+func chain() *rpctest.Chain {
+	chain := rpctest.NewChain(1_000, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	chain.Genesis[account] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Genesis[tracked] = rpctest.Wei(1_000_000_000_000_000)
+
+	chain.Next(&rpctest.Tx{From: other, To: account, Value: rpctest.Wei(1000)})
+	chain.Next(&rpctest.Tx{From: tracked, To: other, Value: rpctest.Wei(500)})
+	chain.Next(&rpctest.Tx{From: account, To: other, Value: rpctest.Wei(300)})
+	chain.Next(&rpctest.Tx{From: other, To: tracked, Value: rpctest.Wei(700)})
+	chain.Next(&rpctest.Tx{From: account, To: tracked, Value: rpctest.Wei(100)})
+	chain.Next()
+
+	return chain
+}
+
+func TestVerify(t *testing.T) {
+	start, early := uint64(1_003), uint64(1_001)
+
+	tests := []struct {
+		name string
+		// First block the follower indexed, the first block of the chain when 0
+		followFrom uint64
+		// Blocks to leave out
+		skip map[uint64]bool
+		// Blocks to leave out for the account only
+		skipAccount map[uint64]bool
+		// Blocks indexed before the blocks of each address were recorded
+		unrecorded map[uint64]bool
+		// Track the address from this block, not at all when nil
+		trackFrom *uint64
+		// Registered without its start block, the follower picked it up at trackFrom
+		noStartBlock bool
+		// Left pending
+		backfillPending bool
+		want            map[string]bool
+		wantMissing     map[string][]database.BlockRange
+	}{
+		{
+			name: "everything indexed",
+			want: map[string]bool{account: true},
+		},
+		{
+			name:        "missing block",
+			skip:        map[uint64]bool{1_002: true},
+			want:        map[string]bool{account: false},
+			wantMissing: map[string][]database.BlockRange{account: {{From: 1_002, To: 1_002}}},
+		},
+		{
+			name:      "tracked from a start block",
+			trackFrom: &start,
+			want:      map[string]bool{account: true, tracked: true},
+		},
+		{
+			name:            "tracked and its backfill pending",
+			trackFrom:       &start,
+			backfillPending: true,
+			want:            map[string]bool{account: true, tracked: true},
+		},
+		{
+			name:         "tracked without a start block",
+			trackFrom:    &start,
+			noStartBlock: true,
+			want:         map[string]bool{account: true, tracked: true},
+		},
+		{
+			name:        "gap before the start block of a tracked address",
+			skip:        map[uint64]bool{1_001: true},
+			trackFrom:   &start,
+			want:        map[string]bool{account: true, tracked: true},
+			wantMissing: map[string][]database.BlockRange{account: {{From: 1_001, To: 1_001}}},
+		},
+		{
+			// The backfill indexes blocks 1001 and 1002 for the tracked address only
+			name:       "tracked from a start block before the first followed block",
+			followFrom: start,
+			trackFrom:  &early,
+			want:       map[string]bool{account: true, tracked: true},
+		},
+		{
+			name:        "gap of the account filled by the backfill of a tracked address",
+			skipAccount: map[uint64]bool{1_002: true},
+			trackFrom:   &early,
+			want:        map[string]bool{account: false, tracked: true},
+			wantMissing: map[string][]database.BlockRange{account: {{From: 1_002, To: 1_002}}},
+		},
+		{
+			name:       "transactions indexed before the blocks of each address were recorded",
+			unrecorded: map[uint64]bool{1_000: true, 1_001: true},
+			want:       map[string]bool{account: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := chain()
+			client, _, store := indexertest.Setup(t, chain)
+			ix := indexer.New(client, store)
+			ctx := context.Background()
+
+			if tt.trackFrom != nil {
+				startBlock := tt.trackFrom
+				if tt.noStartBlock {
+					startBlock = nil
+				}
+				if _, _, err := store.AddTrackedAddress(ctx, tracked, startBlock); err != nil {
+					t.Fatalf("AddTrackedAddress: %v", err)
+				}
+			}
+
+			followFrom := chain.Blocks[1].Number
+			if tt.followFrom != 0 {
+				followFrom = tt.followFrom
+			}
+
+			for number := chain.Blocks[1].Number; number <= chain.Head(); number++ {
+				if tt.skip[number] {
+					continue
+				}
+				accounts := map[string]bool{}
+				if number >= followFrom && !tt.skipAccount[number] {
+					accounts[account] = true
+				}
+				if tt.trackFrom != nil && number >= *tt.trackFrom {
+					accounts[tracked] = true
+				}
+				if len(accounts) == 0 {
+					continue
+				}
+				indexed, err := ix.ProcessBlock(ctx, number, accounts)
+				if err != nil {
+					t.Fatalf("ProcessBlock %d: %v", number, err)
+				}
+				if tt.unrecorded[number] {
+					indexed.Accounts = nil
+				}
+				if err := ix.Commit(ctx, "", indexed); err != nil {
+					t.Fatalf("Commit %d: %v", number, err)
+				}
+			}
+
+			if tt.trackFrom != nil && !tt.noStartBlock && !tt.backfillPending {
+				// The follower picked the address up at its first block, the blocks before are backfilled
+				end := max(*tt.trackFrom, followFrom) - 1
+				if _, err := store.ScheduleBackfills(ctx, end); err != nil {
+					t.Fatalf("ScheduleBackfills: %v", err)
+				}
+				job, _, err := store.ClaimJob(ctx, time.Minute)
+				if err != nil {
+					t.Fatalf("ClaimJob: %v", err)
+				}
+				if err := store.CompleteJob(ctx, job.ID); err != nil {
+					t.Fatalf("CompleteJob: %v", err)
+				}
+			}
+
+			targets, err := verifyTargets(ctx, store, []string{account})
+			if err != nil {
+				t.Fatalf("verifyTargets: %v", err)
+			}
+			if len(targets) != len(tt.want) {
+				t.Fatalf("got %d targets, want %d: %+v", len(targets), len(tt.want), targets)
+			}
+
+			for _, target := range targets {
+				res, err := verifyTarget(ctx, store, client, target, chain.Head())
+				if err != nil {
+					t.Fatalf("verifyTarget %s: %v", target.address, err)
+				}
+
+				if res.matches() != tt.want[target.address] {
+					t.Errorf("%s: indexed %s, on-chain %s, want match %t", target.address, res.indexed, res.onChain, tt.want[target.address])
+				}
+				if want := tt.wantMissing[target.address]; len(res.missing) != len(want) || (len(want) > 0 && res.missing[0] != want[0]) {
+					t.Errorf("%s: missing %+v, want %+v", target.address, res.missing, want)
+				}
+				if pending := res.backfill != nil; target.address == tracked && pending != tt.backfillPending {
+					t.Errorf("%s: backfill %+v, want pending %t", target.address, res.backfill, tt.backfillPending)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 )
@@ -10,11 +11,13 @@ import (
 type Config struct {
 	// Addresses I will index
 	Addresses []string `env:"ADDRESSES,required"`
-	// Block to prefetch, then use the latest one as the startIdx
-	Blocks   []uint64 `env:"BLOCKS,required"`
+	// Block to prefetch, only used when the indexer runs in "blocks" mode
+	Blocks   []uint64 `env:"BLOCKS"`
 	Database DBConfig
 	BaseAPI  BaseAPIConfig
 	Server   ServerConfig
+	Indexer  IndexerConfig
+	Verify   VerifyConfig
 }
 
 type DBConfig struct {
@@ -23,19 +26,107 @@ type DBConfig struct {
 	Name     string `env:"DB_NAME,required"`
 	Host     string `env:"DB_HOST,default=localhost"`
 	Port     uint16 `env:"DB_PORT,default=5432"`
+
+	// Connection pool, shared by every goroutine of the process
+	MaxConns          int32         `env:"DB_MAX_CONNS,default=10"`
+	MinConns          int32         `env:"DB_MIN_CONNS,default=1"`
+	MaxConnLifetime   time.Duration `env:"DB_MAX_CONN_LIFETIME,default=1h"`
+	MaxConnIdleTime   time.Duration `env:"DB_MAX_CONN_IDLE_TIME,default=30m"`
+	HealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD,default=1m"`
 }
 
 type ServerConfig struct {
 	Port uint16 `env:"API_PORT,default=3000"`
+	// Bearer token of the admin endpoints, they are disabled when empty
+	AdminToken string `env:"ADMIN_TOKEN"`
+}
+
+const (
+	// Process the BLOCKS list once and exit
+	IndexModeBlocks = "blocks"
+	// Keep tailing the chain head
+	IndexModeFollow = "follow"
+	// Walk every block between START_BLOCK and END_BLOCK once and exit
+	IndexModeRange = "range"
+	// Only run the jobs of the queue
+	IndexModeJobs = "jobs"
+)
+
+type IndexerConfig struct {
+	Mode string `env:"INDEX_MODE,default=blocks"`
+	// Name of the checkpoint to resume from, derived from the mode when empty
+	Job string `env:"INDEX_JOB"`
+	// In "follow" mode, block to start from when nothing has been indexed yet, 0 means the current head.
+	// In "range" mode, first block of the range.
+	StartBlock uint64 `env:"START_BLOCK"`
+	// Last block of the range (inclusive), only used in "range" mode
+	EndBlock uint64 `env:"END_BLOCK"`
+	// How often to ask the node for a new head
+	PollInterval time.Duration `env:"POLL_INTERVAL,default=2s"`
+	// How many blocks to stay behind the head, so we don't index blocks that are likely to be reorged
+	Confirmations uint64 `env:"CONFIRMATIONS,default=10"`
+	// How many blocks to fetch from the node in parallel, they are still stored in order
+	Concurrency int `env:"INDEX_CONCURRENCY,default=4"`
+	// How many blocks to walk back looking for the common ancestor when following the head hits a reorg
+	MaxReorgDepth uint64 `env:"MAX_REORG_DEPTH,default=64"`
+	// How many jobs of the queue to run in parallel in "follow" and "jobs" modes, 0 disables them in "follow" mode
+	Workers int `env:"INDEX_WORKERS,default=1"`
+	// Failed attempts before a job is marked as failed
+	JobMaxAttempts int `env:"JOB_MAX_ATTEMPTS,default=3"`
+	// Wait before retrying a failed job, doubled on every failed attempt
+	JobRetryDelay time.Duration `env:"JOB_RETRY_DELAY,default=1m"`
+	// A running job without a heartbeat for this long is considered abandoned and claimed again
+	JobStaleAfter time.Duration `env:"JOB_STALE_AFTER,default=5m"`
+}
+
+// JobName identifies the checkpoint of the "follow" and "range" modes. Different ranges get
+// different checkpoints, so backfilling a window doesn't move the checkpoint of the follower.
+func (ic IndexerConfig) JobName() string {
+	if ic.Job != "" {
+		return ic.Job
+	}
+
+	if ic.Mode == IndexModeRange {
+		return fmt.Sprintf("range_%d_%d", ic.StartBlock, ic.EndBlock)
+	}
+
+	return ic.Mode
+}
+
+type VerifyConfig struct {
+	// Also report the block ranges missing between the first and the last indexed block
+	MissingRanges bool `env:"VERIFY_MISSING_RANGES,default=false"`
 }
 
 func (dbc DBConfig) String() string {
-	return fmt.Sprintf("postgresql://%s:%s@%s/%s?connect_timeout=5", dbc.Username, dbc.Password, dbc.Host, dbc.Name)
+	return fmt.Sprintf("postgresql://%s:%s@%s:%d/%s?connect_timeout=5", dbc.Username, dbc.Password, dbc.Host, dbc.Port, dbc.Name)
 }
 
 type BaseAPIConfig struct {
-	BaseURL      string `env:"BASE_API_BASE_URL,default=https://base-rpc.publicnode.com"`
-	BaseDebugURL string `env:"BASE_API_BASE_DEBUG_URL,default=https://docs-demo.base-mainnet.quiknode.pro"`
+	// Comma separated lists of endpoints, each one optionally followed by |weight, e.g. https://a|3,https://b
+	BaseURLs      []string `env:"BASE_API_BASE_URL,default=https://base-rpc.publicnode.com"`
+	BaseDebugURLs []string `env:"BASE_API_BASE_DEBUG_URL,default=https://docs-demo.base-mainnet.quiknode.pro"`
+	// "failover" or "round-robin"
+	Strategy string `env:"RPC_STRATEGY,default=failover"`
+	// Consecutive failures before an endpoint stops getting calls for UnhealthyCooldown
+	MaxFailures       int           `env:"RPC_MAX_FAILURES,default=3"`
+	UnhealthyCooldown time.Duration `env:"RPC_UNHEALTHY_COOLDOWN,default=30s"`
+	// How many blocks an endpoint can be behind the others before it stops getting calls
+	MaxLag uint64 `env:"RPC_MAX_LAG,default=10"`
+	// Deadline of every attempt of a call
+	Timeout time.Duration `env:"RPC_TIMEOUT,default=30s"`
+	// How many times to retry a call that failed with a retryable error, e.g. a 429 or a 5xx
+	MaxRetries int `env:"RPC_MAX_RETRIES,default=5"`
+	// Backoff before the first retry, doubled on every attempt up to RetryMaxDelay
+	RetryBaseDelay time.Duration `env:"RPC_RETRY_BASE_DELAY,default=500ms"`
+	RetryMaxDelay  time.Duration `env:"RPC_RETRY_MAX_DELAY,default=30s"`
+	// Requests per second allowed for each endpoint, 0 means unlimited
+	RateLimit float64 `env:"RPC_RATE_LIMIT,default=0"`
+	RateBurst int     `env:"RPC_RATE_BURST,default=1"`
+	// Save every exchange with the nodes to this directory, to replay it later with RPC_REPLAY_DIR
+	RecordDir string `env:"RPC_RECORD_DIR"`
+	// Answer from the exchanges recorded in this directory instead of calling the nodes
+	ReplayDir string `env:"RPC_REPLAY_DIR"`
 }
 
 func New(ctx context.Context) (*Config, error) {
@@ -43,5 +134,44 @@ func New(ctx context.Context) (*Config, error) {
 	if err := envconfig.Process(context.Background(), &cfg); err != nil {
 		return nil, fmt.Errorf("error loading config: %w", err)
 	}
+
+	if cfg.BaseAPI.Strategy != "failover" && cfg.BaseAPI.Strategy != "round-robin" {
+		return nil, fmt.Errorf("error loading config: unknown RPC_STRATEGY %q", cfg.BaseAPI.Strategy)
+	}
+
+	if cfg.BaseAPI.RecordDir != "" && cfg.BaseAPI.ReplayDir != "" {
+		return nil, fmt.Errorf("error loading config: only one of RPC_RECORD_DIR and RPC_REPLAY_DIR can be set")
+	}
+
+	if cfg.Database.MaxConns < 1 || cfg.Database.MinConns < 0 || cfg.Database.MinConns > cfg.Database.MaxConns {
+		return nil, fmt.Errorf("error loading config: DB_MIN_CONNS must be between 0 and DB_MAX_CONNS, and DB_MAX_CONNS at least 1")
+	}
+
+	if cfg.Indexer.Concurrency < 1 {
+		return nil, fmt.Errorf("error loading config: INDEX_CONCURRENCY must be at least 1")
+	}
+
+	if cfg.Indexer.Workers < 0 || cfg.Indexer.JobMaxAttempts < 1 {
+		return nil, fmt.Errorf("error loading config: INDEX_WORKERS can't be negative and JOB_MAX_ATTEMPTS must be at least 1")
+	}
+
+	if cfg.Indexer.JobStaleAfter <= 0 || cfg.Indexer.JobRetryDelay < 0 {
+		return nil, fmt.Errorf("error loading config: JOB_STALE_AFTER must be positive and JOB_RETRY_DELAY can't be negative")
+	}
+
+	switch cfg.Indexer.Mode {
+	case IndexModeBlocks, IndexModeFollow:
+	case IndexModeJobs:
+		if cfg.Indexer.Workers < 1 {
+			return nil, fmt.Errorf("error loading config: INDEX_WORKERS must be at least 1 in jobs mode")
+		}
+	case IndexModeRange:
+		if cfg.Indexer.EndBlock < cfg.Indexer.StartBlock {
+			return nil, fmt.Errorf("error loading config: END_BLOCK %d is before START_BLOCK %d", cfg.Indexer.EndBlock, cfg.Indexer.StartBlock)
+		}
+	default:
+		return nil, fmt.Errorf("error loading config: unknown INDEX_MODE %q", cfg.Indexer.Mode)
+	}
+
 	return &cfg, nil
 }
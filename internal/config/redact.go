@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// String prints the config without the secrets, so it can be logged: the database password,
+// the admin token, and the paths, queries and credentials of the node URLs, where providers
+// put the API keys.
+func (c Config) String() string {
+	// Without the String method, so printing it doesn't end up here again
+	type plain Config
+	p := plain(c)
+
+	if p.Database.Password != "" {
+		p.Database.Password = redacted
+	}
+	if p.Server.AdminToken != "" {
+		p.Server.AdminToken = redacted
+	}
+	p.BaseAPI.BaseURLs = redactURLs(p.BaseAPI.BaseURLs)
+	p.BaseAPI.BaseDebugURLs = redactURLs(p.BaseAPI.BaseDebugURLs)
+
+	return fmt.Sprintf("%+v", p)
+}
+
+func redactURLs(urls []string) []string {
+	redactedURLs := make([]string, len(urls))
+	for i, u := range urls {
+		redactedURLs[i] = RedactURL(u)
+	}
+
+	return redactedURLs
+}
+
+// RedactURL keeps the scheme and host of the URL, and the |weight of a pool endpoint.
+func RedactURL(raw string) string {
+	raw, weight, hasWeight := strings.Cut(raw, "|")
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+
+	redactedURL := u.Scheme + "://" + u.Host
+	if u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		redactedURL += "/" + redacted
+	}
+	if hasWeight {
+		redactedURL += "|" + weight
+	}
+
+	return redactedURL
+}
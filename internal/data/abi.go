@@ -0,0 +1,108 @@
+package data
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Event signatures, keccak256 of the canonical event declaration
+const (
+	// Transfer(address,address,uint256), shared by ERC-20 and ERC-721
+	TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// TransferSingle(address,address,address,uint256,uint256), ERC-1155
+	TransferSingleTopic = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+	// TransferBatch(address,address,address,uint256[],uint256[]), ERC-1155
+	TransferBatchTopic = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+)
+
+// Function selectors, first 4 bytes of keccak256 of the canonical function declaration
+const (
+	NameSelector     = "0x06fdde03" // name()
+	SymbolSelector   = "0x95d89b41" // symbol()
+	DecimalsSelector = "0x313ce567" // decimals()
+)
+
+// AddressToTopic left pads the address to 32 bytes, the way indexed address arguments are stored in topics.
+func AddressToTopic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
+
+// TopicToAddress takes the last 20 bytes of a topic.
+func TopicToAddress(topic string) (string, error) {
+	topic = strings.TrimPrefix(topic, "0x")
+	if len(topic) != 64 {
+		return "", fmt.Errorf("invalid topic length: %s", topic)
+	}
+	return "0x" + strings.ToLower(topic[24:]), nil
+}
+
+// Words splits ABI encoded data in its 32 bytes words.
+func Words(hexData string) ([]*big.Int, error) {
+	hexData = strings.TrimPrefix(hexData, "0x")
+	if len(hexData)%64 != 0 {
+		return nil, fmt.Errorf("invalid abi data length: %d", len(hexData))
+	}
+
+	words := make([]*big.Int, 0, len(hexData)/64)
+	for i := 0; i < len(hexData); i += 64 {
+		word, ok := new(big.Int).SetString(hexData[i:i+64], 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid abi word: %s", hexData[i:i+64])
+		}
+		words = append(words, word)
+	}
+
+	return words, nil
+}
+
+// DecodeUintArray decodes a dynamic uint256[] out of the words of the data, offset being the
+// head word pointing at it, in bytes from the start of the data.
+func DecodeUintArray(words []*big.Int, offset *big.Int) ([]*big.Int, error) {
+	if !offset.IsUint64() || offset.Uint64()%32 != 0 || offset.Uint64()/32 >= uint64(len(words)) {
+		return nil, fmt.Errorf("invalid abi array offset: %s", offset)
+	}
+	start := offset.Uint64() / 32
+
+	// Compared against what is left of the data, so a huge length can't overflow past the check
+	length := words[start]
+	if !length.IsUint64() || length.Uint64() > uint64(len(words))-start-1 {
+		return nil, fmt.Errorf("invalid abi array length: %s", length)
+	}
+
+	return words[start+1 : start+1+length.Uint64()], nil
+}
+
+// DecodeString decodes an ABI encoded string return value. Some old tokens return a bytes32
+// instead, right padded with zeroes, which is supported as well.
+func DecodeString(hexData string) (string, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(hexData, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("invalid abi data: %w", err)
+	}
+
+	if len(raw) == 32 {
+		return string(bytes.TrimRight(raw, "\x00")), nil
+	}
+
+	if len(raw) < 64 {
+		return "", fmt.Errorf("invalid abi string length: %d", len(raw))
+	}
+
+	// Offset and length are compared against what is left of the data, so huge values can't
+	// overflow past the checks
+	offset := new(big.Int).SetBytes(raw[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(raw))-32 {
+		return "", fmt.Errorf("invalid abi string offset: %s", offset)
+	}
+	start := offset.Uint64() + 32
+
+	length := new(big.Int).SetBytes(raw[start-32 : start])
+	if !length.IsUint64() || length.Uint64() > uint64(len(raw))-start {
+		return "", fmt.Errorf("invalid abi string length: %s", length)
+	}
+
+	return string(raw[start : start+length.Uint64()]), nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// word left pads a hex number to a 32 bytes ABI word.
+func word(hexNumber string) string {
+	return strings.Repeat("0", 64-len(hexNumber)) + hexNumber
+}
+
+func TestDecodeUintArray(t *testing.T) {
+	maxUint := strings.Repeat("f", 16)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []int64
+		wantErr bool
+	}{
+		{name: "two items", data: word("20") + word("2") + word("7") + word("9"), want: []int64{7, 9}},
+		{name: "empty", data: word("20") + word("0"), want: []int64{}},
+		{name: "length past the data", data: word("20") + word("3") + word("7"), wantErr: true},
+		{name: "max uint64 length", data: word("20") + word(maxUint), wantErr: true},
+		{name: "length overflowing by one", data: word("20") + word(strings.Repeat("f", 15)+"e") + word("7"), wantErr: true},
+		{name: "offset past the data", data: word("40") + word("1"), wantErr: true},
+		{name: "unaligned offset", data: word("21") + word("1") + word("1"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			words, err := Words("0x" + tt.data)
+			if err != nil {
+				t.Fatalf("Words: %v", err)
+			}
+
+			got, err := DecodeUintArray(words, words[0])
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeUintArray: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if got[i].Cmp(big.NewInt(want)) != 0 {
+					t.Errorf("item %d = %s, want %d", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeString(t *testing.T) {
+	maxUint := strings.Repeat("f", 16)
+	// "USD Coin", right padded to a word
+	usdc := "55534420436f696e" + strings.Repeat("0", 48)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "string", data: word("20") + word("8") + usdc, want: "USD Coin"},
+		{name: "bytes32", data: usdc, want: "USD Coin"},
+		{name: "empty string", data: word("20") + word("0"), want: ""},
+		{name: "length past the data", data: word("20") + word("21") + usdc, wantErr: true},
+		{name: "max uint64 length", data: word("20") + word(maxUint) + usdc, wantErr: true},
+		{name: "max uint64 offset", data: word(maxUint) + word("8") + usdc, wantErr: true},
+		{name: "offset past the data", data: word("60") + word("8") + usdc, wantErr: true},
+		{name: "too short", data: word("20")[:62], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeString("0x" + tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeString: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,60 +2,52 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shopspring/decimal"
 
 	"github.com/danilevy1212/baseidx-wt/internal/config"
-	"github.com/danilevy1212/baseidx-wt/internal/data"
 )
 
+// DBClient is safe for concurrent use, every call borrows a connection from the pool.
 type DBClient struct {
-	Conn *pgx.Conn
+	Pool *pgxpool.Pool
 }
 
 func New(ctx context.Context, c config.DBConfig) (*DBClient, error) {
-	conn, err := pgx.Connect(ctx, c.String())
+	poolCfg, err := pgxpool.ParseConfig(c.String())
+	if err != nil {
+		return nil, err
+	}
 
+	poolCfg.MaxConns = c.MaxConns
+	poolCfg.MinConns = c.MinConns
+	poolCfg.MaxConnLifetime = c.MaxConnLifetime
+	poolCfg.MaxConnIdleTime = c.MaxConnIdleTime
+	poolCfg.HealthCheckPeriod = c.HealthCheckPeriod
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, err
 	}
 
 	return &DBClient{
-		Conn: conn,
+		Pool: pool,
 	}, nil
 }
 
-func (db *DBClient) Close(ctx context.Context) error {
-	return db.Conn.Close(ctx)
+func (db *DBClient) Close() {
+	db.Pool.Close()
 }
 
 func (db *DBClient) Ping(ctx context.Context) error {
-	return db.Conn.Ping(ctx)
-}
-
-func (db *DBClient) CreateSchema(ctx context.Context) error {
-	_, err := db.Conn.Exec(ctx, `
-	CREATE TABLE transactions (
-    	hash TEXT PRIMARY KEY,
-    	type TEXT NOT NULL CHECK (type IN ('transfer', 'call', 'fee')),
-    	value NUMERIC NOT NULL,
-    	from_address TEXT NOT NULL,
-    	to_address TEXT NOT NULL,
-    	block_index TEXT NOT NULL,
-    	succesful BOOLEAN NOT NULL,
-    	timestamp TIMESTAMPTZ NOT NULL
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_transactions_from ON transactions(from_address);
-	CREATE INDEX IF NOT EXISTS idx_transactions_to ON transactions(to_address);
-	CREATE INDEX IF NOT EXISTS idx_transactions_from_timestamp ON transactions(from_address, timestamp DESC);
-	CREATE INDEX IF NOT EXISTS idx_transactions_to_timestamp ON transactions(to_address, timestamp DESC);
-	`)
-
-	return err
+	return db.Pool.Ping(ctx)
 }
 
 func (db *DBClient) UpsertTransactions(ctx context.Context, txs []Transaction) error {
@@ -63,33 +55,316 @@ func (db *DBClient) UpsertTransactions(ctx context.Context, txs []Transaction) e
 		return nil
 	}
 
+	return db.Pool.SendBatch(ctx, upsertTransactionsBatch(txs)).Close()
+}
+
+// CommitBlock stores a block with everything extracted from it, and moves the checkpoint of
+// the job to it in a single database transaction, so the checkpoint never gets ahead of the
+// stored data. If job is empty, no checkpoint is recorded.
+func (db *DBClient) CommitBlock(ctx context.Context, job string, indexed IndexedBlock) error {
+	dbTx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback(ctx)
+
+	block := indexed.Block
+
+	batch := upsertTransactionsBatch(indexed.Transactions)
+	queueTokenTransfers(batch, indexed.TokenTransfers)
+	queueNFTTransfers(batch, indexed.NFTTransfers)
+	batch.Queue(`
+		INSERT INTO blocks (number, hash, parent_hash, timestamp)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (number) DO UPDATE SET
+			hash = EXCLUDED.hash,
+			parent_hash = EXCLUDED.parent_hash,
+			timestamp = EXCLUDED.timestamp;
+	`, block.Number, block.Hash, block.ParentHash, block.Timestamp)
+	if len(indexed.Accounts) > 0 {
+		batch.Queue(`
+			INSERT INTO address_blocks (address, block_number)
+			SELECT UNNEST($1::TEXT[]), $2
+			ON CONFLICT DO NOTHING;
+		`, indexed.Accounts, block.Number)
+	}
+
+	if job != "" {
+		queueCheckpoint(batch, job, block.Number)
+	}
+
+	if err := dbTx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return dbTx.Commit(ctx)
+}
+
+// RollbackBlocks removes every block from fromBlock onwards, together with their transactions,
+// and moves the checkpoint of the job back to the block before it. Used when a reorg orphans
+// blocks we already indexed. The jobs of the queue that covered those blocks are rewound, see
+// queueJobRollback.
+func (db *DBClient) RollbackBlocks(ctx context.Context, job string, fromBlock uint64) error {
+	dbTx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback(ctx)
+
+	rows, err := dbTx.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE to_block >= $1
+		ORDER BY id
+		FOR UPDATE;
+	`, fromBlock)
+	if err != nil {
+		return err
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	for _, j := range jobs {
+		queueJobRollback(batch, j, fromBlock)
+	}
+	batch.Queue(`DELETE FROM transactions WHERE block_number >= $1;`, fromBlock)
+	batch.Queue(`DELETE FROM token_transfers WHERE block_number >= $1;`, fromBlock)
+	batch.Queue(`DELETE FROM nft_transfers WHERE block_number >= $1;`, fromBlock)
+	batch.Queue(`DELETE FROM blocks WHERE number >= $1;`, fromBlock)
+	batch.Queue(`DELETE FROM address_blocks WHERE block_number >= $1;`, fromBlock)
+	if fromBlock > 0 {
+		queueCheckpoint(batch, job, fromBlock-1)
+	} else {
+		batch.Queue(`DELETE FROM indexer_state WHERE job = $1;`, job)
+	}
+
+	if err := dbTx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return dbTx.Commit(ctx)
+}
+
+// GetBlock returns the stored header of a block, if we indexed it.
+func (db *DBClient) GetBlock(ctx context.Context, number uint64) (Block, bool, error) {
+	var block Block
+
+	err := db.Pool.QueryRow(ctx, `
+		SELECT number, hash, parent_hash, timestamp
+		FROM blocks
+		WHERE number = $1;
+	`, number).Scan(&block.Number, &block.Hash, &block.ParentHash, &block.Timestamp)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Block{}, false, nil
+	}
+	if err != nil {
+		return Block{}, false, err
+	}
+
+	return block, true, nil
+}
+
+// queueJobRollback rewinds the progress and the checkpoint of a job to the block before
+// fromBlock, and puts it back in the queue if it was done. A running job gets a reindex of the
+// rolled back blocks queued instead.
+func queueJobRollback(batch *pgx.Batch, job Job, fromBlock uint64) {
+	if job.Status == JobRunning {
+		reindex := rollbackReindex(job, fromBlock)
+		batch.Queue(`
+			INSERT INTO jobs (kind, address, from_block, to_block)
+			VALUES ($1, $2, $3, $4);
+		`, reindex.Kind, reindex.Address, reindex.FromBlock, reindex.ToBlock)
+		return
+	}
+
+	batch.Queue(`
+		UPDATE jobs
+		SET progress = $2,
+			status = CASE WHEN status = 'done' THEN 'pending' ELSE status END,
+			finished_at = CASE WHEN status = 'done' THEN NULL ELSE finished_at END,
+			updated_at = NOW()
+		WHERE id = $1;
+	`, job.ID, rewoundProgress(job, fromBlock))
+
+	if fromBlock > job.FromBlock {
+		batch.Queue(`
+			UPDATE indexer_state
+			SET last_block = $2, updated_at = NOW()
+			WHERE job = $1 AND last_block >= $3;
+		`, JobCheckpoint(job.ID), fromBlock-1, fromBlock)
+	} else {
+		batch.Queue(`DELETE FROM indexer_state WHERE job = $1;`, JobCheckpoint(job.ID))
+	}
+}
+
+func queueCheckpoint(batch *pgx.Batch, job string, blockIdx uint64) {
+	batch.Queue(`
+		INSERT INTO indexer_state (job, last_block, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (job) DO UPDATE SET
+			last_block = EXCLUDED.last_block,
+			updated_at = EXCLUDED.updated_at;
+	`, job, blockIdx)
+}
+
+func upsertTransactionsBatch(txs []Transaction) *pgx.Batch {
 	batch := &pgx.Batch{}
 
 	for _, tx := range txs {
 		batch.Queue(`
-			INSERT INTO transactions (hash, type, value, from_address, to_address, block_index, succesful, timestamp)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			INSERT INTO transactions (hash, type, value, from_address, to_address, block_index, block_number, succesful, timestamp)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 			ON CONFLICT (hash) DO UPDATE SET
 				type = EXCLUDED.type,
 				value = EXCLUDED.value,
 				from_address = EXCLUDED.from_address,
 				to_address = EXCLUDED.to_address,
 				block_index = EXCLUDED.block_index,
+				block_number = EXCLUDED.block_number,
 				succesful = EXCLUDED.succesful,
 				timestamp = EXCLUDED.timestamp;
-		`, tx.Hash, tx.Type, tx.Value, tx.From, tx.To, tx.BlockIndex, tx.Succesful, tx.Timestamp)
+		`, tx.Hash, tx.Type, tx.Value, tx.From, tx.To, tx.BlockIndex, tx.BlockNumber, tx.Succesful, tx.Timestamp)
+	}
+
+	return batch
+}
+
+func queueTokenTransfers(batch *pgx.Batch, transfers []TokenTransfer) {
+	for _, t := range transfers {
+		batch.Queue(`
+			INSERT INTO token_transfers (tx_hash, log_index, token_address, from_address, to_address, value, block_index, block_number, timestamp)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (tx_hash, log_index) DO UPDATE SET
+				token_address = EXCLUDED.token_address,
+				from_address = EXCLUDED.from_address,
+				to_address = EXCLUDED.to_address,
+				value = EXCLUDED.value,
+				block_index = EXCLUDED.block_index,
+				block_number = EXCLUDED.block_number,
+				timestamp = EXCLUDED.timestamp;
+		`, t.TransactionHash, t.LogIndex, t.Token, t.From, t.To, t.Value, t.BlockIndex, t.BlockNumber, t.Timestamp)
+	}
+}
+
+func queueNFTTransfers(batch *pgx.Batch, transfers []NFTTransfer) {
+	for _, t := range transfers {
+		batch.Queue(`
+			INSERT INTO nft_transfers (tx_hash, log_index, batch_index, contract_address, standard, token_id, amount, from_address, to_address, block_index, block_number, timestamp)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (tx_hash, log_index, batch_index) DO UPDATE SET
+				contract_address = EXCLUDED.contract_address,
+				standard = EXCLUDED.standard,
+				token_id = EXCLUDED.token_id,
+				amount = EXCLUDED.amount,
+				from_address = EXCLUDED.from_address,
+				to_address = EXCLUDED.to_address,
+				block_index = EXCLUDED.block_index,
+				block_number = EXCLUDED.block_number,
+				timestamp = EXCLUDED.timestamp;
+		`, t.TransactionHash, t.LogIndex, t.BatchIndex, t.Contract, t.Standard, t.TokenID, t.Amount, t.From, t.To, t.BlockIndex, t.BlockNumber, t.Timestamp)
 	}
+}
+
+// GetCheckpoint returns the highest block the job has contiguously processed.
+func (db *DBClient) GetCheckpoint(ctx context.Context, job string) (uint64, bool, error) {
+	var lastBlock uint64
+
+	err := db.Pool.QueryRow(ctx, `
+		SELECT last_block
+		FROM indexer_state
+		WHERE job = $1;
+	`, job).Scan(&lastBlock)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return lastBlock, true, nil
+}
+
+// GetLastIndexedBlock returns the highest block we have stored.
+func (db *DBClient) GetLastIndexedBlock(ctx context.Context) (uint64, bool, error) {
+	var number *uint64
+
+	err := db.Pool.QueryRow(ctx, `
+		SELECT MAX(number)
+		FROM blocks;
+	`).Scan(&number)
+
+	if err != nil {
+		return 0, false, err
+	}
+	if number == nil {
+		return 0, false, nil
+	}
+
+	return *number, true, nil
+}
+
+// GetFirstIndexedBlock returns the lowest block indexed for the address.
+func (db *DBClient) GetFirstIndexedBlock(ctx context.Context, address string) (uint64, bool, error) {
+	var number *uint64
+
+	err := db.Pool.QueryRow(ctx, `
+		SELECT MIN(block_number)
+		FROM address_blocks
+		WHERE address = $1;
+	`, address).Scan(&number)
+
+	if err != nil {
+		return 0, false, err
+	}
+	if number == nil {
+		return 0, false, nil
+	}
+
+	return *number, true, nil
+}
 
-	br := db.Conn.SendBatch(ctx, batch)
-	defer br.Close()
+// GetMissingBlockRanges returns the blocks between from and to (inclusive) that weren't indexed
+// for the address. Blocks indexed only for other addresses count as missing.
+func (db *DBClient) GetMissingBlockRanges(ctx context.Context, address string, from, to uint64) ([]BlockRange, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT number + 1, next_number - 1
+		FROM (
+			SELECT number, LEAD(number, 1, $3::BIGINT + 1) OVER (ORDER BY number) AS next_number
+			FROM (
+				SELECT $2::BIGINT - 1 AS number
+				UNION ALL
+				SELECT block_number
+				FROM address_blocks
+				WHERE address = $1 AND block_number BETWEEN $2 AND $3
+			) indexed
+		) b
+		WHERE next_number > number + 1
+		ORDER BY number;
+	`, address, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	for range txs {
-		if _, err := br.Exec(); err != nil {
-			return err
+	ranges := []BlockRange{}
+	for rows.Next() {
+		var r BlockRange
+		if err := rows.Scan(&r.From, &r.To); err != nil {
+			return nil, err
 		}
+		ranges = append(ranges, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return ranges, nil
 }
 
 type GetBalanceResult struct {
@@ -97,25 +372,48 @@ type GetBalanceResult struct {
 	Transactions uint64
 }
 
+// BalanceAsOf limits the balance to the transactions up to a block or a point in time
+// (both inclusive). The zero value means the current balance.
+type BalanceAsOf struct {
+	Block *uint64
+	Time  *time.Time
+}
+
+// balanceDeltaSQL is how much a transaction row changes the balance of the address in $1.
+// Fees are paid even if the transaction failed, the value only moves if it went through.
+const balanceDeltaSQL = `
+	CASE
+		WHEN type = 'fee' AND from_address = $1 THEN -value
+		WHEN succesful = TRUE AND from_address = $1 THEN -value
+		WHEN succesful = TRUE AND to_address   = $1 THEN  value
+		ELSE 0
+	END`
+
 func (db *DBClient) GetBalance(ctx context.Context, address string) (GetBalanceResult, error) {
+	return db.GetBalanceAsOf(ctx, address, BalanceAsOf{})
+}
+
+func (db *DBClient) GetBalanceAsOf(ctx context.Context, address string, asOf BalanceAsOf) (GetBalanceResult, error) {
 	var result GetBalanceResult
 
 	log.Printf("Getting balance for address %s", address)
 
-	err := db.Conn.QueryRow(ctx, `
+	q := newQuery()
+	q.where("(from_address = %[1]s OR to_address = %[1]s)", address)
+	if asOf.Block != nil {
+		q.where("block_number <= %s", *asOf.Block)
+	}
+	if asOf.Time != nil {
+		q.where("timestamp <= %s", *asOf.Time)
+	}
+
+	err := db.Pool.QueryRow(ctx, fmt.Sprintf(`
 		SELECT
-			COALESCE(SUM(
-				CASE
-					WHEN type = 'fee' AND from_address = $1 THEN -value
-					WHEN succesful = TRUE AND from_address = $1 THEN -value
-					WHEN succesful = TRUE AND to_address   = $1 THEN  value
-					ELSE 0
-				END
-			), 0),
+			COALESCE(SUM(%s), 0),
 			COUNT(*)
 		FROM transactions
-		WHERE from_address = $1 OR to_address = $1;
-	`, address).Scan(&result.Balance, &result.Transactions)
+		WHERE %s;
+	`, balanceDeltaSQL, q.conditions()), q.args...).Scan(&result.Balance, &result.Transactions)
 
 	if err != nil {
 		log.Printf("Error getting balance for address %s: %v", address, err)
@@ -125,73 +423,255 @@ func (db *DBClient) GetBalance(ctx context.Context, address string) (GetBalanceR
 	return result, nil
 }
 
-func (db *DBClient) GetTransactionsFromAddress(ctx context.Context, address string) ([]Transaction, error) {
-	rows, err := db.Conn.Query(ctx, `
-		SELECT hash, type, value, from_address, to_address, block_index, succesful, timestamp AT TIME ZONE 'UTC'
-		FROM transactions
-		WHERE from_address = $1 OR to_address = $1
-		ORDER BY timestamp DESC;
-	`, address)
+// GetBalanceHistory returns the running balance of the address at the end of every interval
+// ("hour", "day" or "week") between start and end, including the empty ones. The first bucket
+// starts from the balance accumulated before it.
+func (db *DBClient) GetBalanceHistory(ctx context.Context, address, interval string, start, end time.Time) ([]BalancePoint, error) {
+	rows, err := db.Pool.Query(ctx, fmt.Sprintf(`
+		WITH deltas AS (
+			SELECT
+				date_trunc($2, timestamp AT TIME ZONE 'UTC') AS bucket,
+				SUM(%s) AS change
+			FROM transactions
+			WHERE (from_address = $1 OR to_address = $1) AND timestamp <= $4
+			GROUP BY 1
+		),
+		buckets AS (
+			SELECT generate_series(
+				date_trunc($2, $3::timestamptz AT TIME ZONE 'UTC'),
+				date_trunc($2, $4::timestamptz AT TIME ZONE 'UTC'),
+				('1 ' || $2)::interval
+			) AS bucket
+		),
+		opening AS (
+			SELECT COALESCE(SUM(change), 0) AS balance
+			FROM deltas
+			WHERE bucket < date_trunc($2, $3::timestamptz AT TIME ZONE 'UTC')
+		)
+		SELECT
+			b.bucket,
+			COALESCE(d.change, 0),
+			(SELECT balance FROM opening) + SUM(COALESCE(d.change, 0)) OVER (ORDER BY b.bucket)
+		FROM buckets b
+		LEFT JOIN deltas d ON d.bucket = b.bucket
+		ORDER BY b.bucket;
+	`, balanceDeltaSQL), address, interval, start, end)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	txs := []Transaction{}
+	points := []BalancePoint{}
+	for rows.Next() {
+		var p BalancePoint
+		if err := rows.Scan(&p.Bucket, &p.Change, &p.Balance); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
 
+// GetTokenTransfersFromAddress lists the ERC-20 transfers from or to the address, optionally
+// only the ones of a token contract.
+func (db *DBClient) GetTokenTransfersFromAddress(ctx context.Context, address, token string) ([]TokenTransfer, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT tx_hash, log_index, token_address, from_address, to_address, value, block_index, block_number, timestamp AT TIME ZONE 'UTC'
+		FROM token_transfers
+		WHERE (from_address = $1 OR to_address = $1)
+			AND ($2 = '' OR token_address = $2)
+		ORDER BY block_number DESC, log_index DESC;
+	`, address, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []TokenTransfer{}
 	for rows.Next() {
-		var tx Transaction
+		var t TokenTransfer
 		if err := rows.Scan(
-			&tx.Hash, &tx.Type, &tx.Value, &tx.From, &tx.To,
-			&tx.BlockIndex, &tx.Succesful, &tx.Timestamp,
+			&t.TransactionHash, &t.LogIndex, &t.Token, &t.From, &t.To,
+			&t.Value, &t.BlockIndex, &t.BlockNumber, &t.Timestamp,
 		); err != nil {
 			return nil, err
 		}
 
-		block, _ := data.NewHexFromString(tx.BlockIndex)
-		tx.BlockIndex = block.Int.String()
+		t.BlockIndex = strconv.FormatUint(t.BlockNumber, 10)
 
-		txs = append(txs, tx)
+		transfers = append(transfers, t)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return txs, nil
+	return transfers, nil
 }
 
-func (db *DBClient) GetTransactionsInRange(ctx context.Context, start, end time.Time) ([]Transaction, error) {
-	rows, err := db.Conn.Query(ctx, `
-		SELECT hash, type, value, from_address, to_address, block_index, succesful, timestamp AT TIME ZONE 'UTC'
-		FROM transactions
-		WHERE timestamp >= $1 AND timestamp <= $2
-		ORDER BY timestamp DESC;
-	`, start, end)
+// GetTokenBalances sums the indexed transfers of every token the address sent or received.
+func (db *DBClient) GetTokenBalances(ctx context.Context, address string) ([]TokenBalance, error) {
+	return db.getTokenBalances(ctx, address, "")
+}
+
+// GetTokenBalance is like GetTokenBalances for a single token. Transfers is 0 if the address
+// never sent or received it.
+func (db *DBClient) GetTokenBalance(ctx context.Context, address, token string) (TokenBalance, error) {
+	balances, err := db.getTokenBalances(ctx, address, token)
+	if err != nil {
+		return TokenBalance{}, err
+	}
+
+	if len(balances) == 0 {
+		return TokenBalance{Token: token}, nil
+	}
+
+	return balances[0], nil
+}
+
+func (db *DBClient) getTokenBalances(ctx context.Context, address, token string) ([]TokenBalance, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT
+			t.token_address,
+			SUM(
+				CASE WHEN t.to_address = $1 THEN t.value ELSE 0 END -
+				CASE WHEN t.from_address = $1 THEN t.value ELSE 0 END
+			),
+			COUNT(*),
+			m.token_address IS NOT NULL,
+			COALESCE(m.name, ''),
+			COALESCE(m.symbol, ''),
+			m.decimals
+		FROM token_transfers t
+		LEFT JOIN token_metadata m ON m.token_address = t.token_address
+		WHERE (t.from_address = $1 OR t.to_address = $1)
+			AND ($2 = '' OR t.token_address = $2)
+		GROUP BY t.token_address, m.token_address, m.name, m.symbol, m.decimals
+		ORDER BY t.token_address;
+	`, address, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []TokenBalance{}
+	for rows.Next() {
+		var b TokenBalance
+		var hasMetadata bool
+		var metadata TokenMetadata
+
+		if err := rows.Scan(
+			&b.Token, &b.Balance, &b.Transfers,
+			&hasMetadata, &metadata.Name, &metadata.Symbol, &metadata.Decimals,
+		); err != nil {
+			return nil, err
+		}
+
+		if hasMetadata {
+			metadata.Token = b.Token
+			b.Metadata = &metadata
+		}
+
+		balances = append(balances, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (db *DBClient) UpsertTokenMetadata(ctx context.Context, metadata TokenMetadata) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO token_metadata (token_address, name, symbol, decimals, fetched_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (token_address) DO UPDATE SET
+			name = EXCLUDED.name,
+			symbol = EXCLUDED.symbol,
+			decimals = EXCLUDED.decimals,
+			fetched_at = EXCLUDED.fetched_at;
+	`, metadata.Token, metadata.Name, metadata.Symbol, metadata.Decimals)
+
+	return err
+}
+
+// GetNFTHoldings returns the NFTs the address currently owns: the tokens it received more of than it sent.
+func (db *DBClient) GetNFTHoldings(ctx context.Context, address string) ([]NFTHolding, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT contract_address, standard, token_id, amount
+		FROM (
+			SELECT
+				contract_address,
+				standard,
+				token_id,
+				SUM(
+					CASE WHEN to_address = $1 THEN amount ELSE 0 END -
+					CASE WHEN from_address = $1 THEN amount ELSE 0 END
+				) AS amount
+			FROM nft_transfers
+			WHERE from_address = $1 OR to_address = $1
+			GROUP BY contract_address, standard, token_id
+		) holdings
+		WHERE amount > 0
+		ORDER BY contract_address, token_id;
+	`, address)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holdings := []NFTHolding{}
+	for rows.Next() {
+		var h NFTHolding
+		if err := rows.Scan(&h.Contract, &h.Standard, &h.TokenID, &h.Amount); err != nil {
+			return nil, err
+		}
+		holdings = append(holdings, h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holdings, nil
+}
+
+func (db *DBClient) GetNFTTransfersFromAddress(ctx context.Context, address string) ([]NFTTransfer, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT tx_hash, log_index, batch_index, contract_address, standard, token_id, amount, from_address, to_address, block_index, block_number, timestamp AT TIME ZONE 'UTC'
+		FROM nft_transfers
+		WHERE from_address = $1 OR to_address = $1
+		ORDER BY block_number DESC, log_index DESC, batch_index DESC;
+	`, address)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	txs := []Transaction{}
+	transfers := []NFTTransfer{}
 	for rows.Next() {
-		var tx Transaction
+		var t NFTTransfer
 		if err := rows.Scan(
-			&tx.Hash, &tx.Type, &tx.Value, &tx.From, &tx.To,
-			&tx.BlockIndex, &tx.Succesful, &tx.Timestamp,
+			&t.TransactionHash, &t.LogIndex, &t.BatchIndex, &t.Contract, &t.Standard, &t.TokenID,
+			&t.Amount, &t.From, &t.To, &t.BlockIndex, &t.BlockNumber, &t.Timestamp,
 		); err != nil {
 			return nil, err
 		}
 
-		block, _ := data.NewHexFromString(tx.BlockIndex)
-		tx.BlockIndex = block.Int.String()
+		t.BlockIndex = strconv.FormatUint(t.BlockNumber, 10)
 
-		txs = append(txs, tx)
+		transfers = append(transfers, t)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return txs, nil
+	return transfers, nil
 }
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const jobColumns = `id, kind, address, from_block, to_block, status, progress, error, attempts, run_after, created_at, started_at, finished_at, updated_at`
+
+// JobCheckpoint names the checkpoint a job resumes from.
+func JobCheckpoint(id int64) string {
+	return fmt.Sprintf("job_%d", id)
+}
+
+// rewoundProgress returns how many blocks of the job are still processed once the blocks from
+// fromBlock on are rolled back.
+func rewoundProgress(job Job, fromBlock uint64) uint64 {
+	if fromBlock <= job.FromBlock {
+		return 0
+	}
+	return min(job.Progress, fromBlock-job.FromBlock)
+}
+
+// rollbackReindex is the job that indexes again the blocks rolled back under a running job. Its
+// worker doesn't go back, so rewinding the job itself would leave them out.
+func rollbackReindex(job Job, fromBlock uint64) Job {
+	return Job{Kind: JobKindReindex, Address: job.Address, FromBlock: max(job.FromBlock, fromBlock), ToBlock: job.ToBlock}
+}
+
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Kind, &j.Address, &j.FromBlock, &j.ToBlock, &j.Status, &j.Progress, &j.Error, &j.Attempts, &j.RunAfter, &j.CreatedAt, &j.StartedAt, &j.FinishedAt, &j.UpdatedAt)
+	return j, err
+}
+
+func scanJobs(rows pgx.Rows) ([]Job, error) {
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// EnqueueJob adds a pending job to the queue, only its kind, address and blocks are used.
+func (db *DBClient) EnqueueJob(ctx context.Context, job Job) (Job, error) {
+	return scanJob(db.Pool.QueryRow(ctx, `
+		INSERT INTO jobs (kind, address, from_block, to_block)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+jobColumns+`;
+	`, job.Kind, job.Address, job.FromBlock, job.ToBlock))
+}
+
+// ScheduleBackfills sets the last block of the backfills still waiting for the follower,
+// so the workers can pick them up.
+func (db *DBClient) ScheduleBackfills(ctx context.Context, end uint64) ([]Job, error) {
+	rows, err := db.Pool.Query(ctx, `
+		UPDATE jobs
+		SET to_block = $2, updated_at = NOW()
+		WHERE kind = $1 AND status = 'pending' AND to_block IS NULL
+		RETURNING `+jobColumns+`;
+	`, JobKindBackfill, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanJobs(rows)
+}
+
+// ClaimJob marks the oldest runnable job as running and returns it. Jobs left running for
+// longer than staleAfter without a heartbeat, e.g. by a worker that crashed, are claimed again.
+// Concurrent workers never get the same job.
+func (db *DBClient) ClaimJob(ctx context.Context, staleAfter time.Duration) (Job, bool, error) {
+	job, err := scanJob(db.Pool.QueryRow(ctx, `
+		UPDATE jobs
+		SET status = 'running', started_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE (status = 'pending' AND to_block IS NOT NULL AND run_after <= NOW())
+				OR (status = 'running' AND updated_at < NOW() - make_interval(secs => $1))
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns+`;
+	`, staleAfter.Seconds()))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+
+	return job, true, nil
+}
+
+// UpdateJobProgress records how many blocks the job processed, it also counts as a heartbeat.
+func (db *DBClient) UpdateJobProgress(ctx context.Context, id int64, progress uint64) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE jobs
+		SET progress = $2, updated_at = NOW()
+		WHERE id = $1;
+	`, id, progress)
+
+	return err
+}
+
+// HeartbeatJob tells other workers the running job is still alive, even if it makes no progress.
+func (db *DBClient) HeartbeatJob(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE jobs
+		SET updated_at = NOW()
+		WHERE id = $1 AND status = 'running';
+	`, id)
+
+	return err
+}
+
+func (db *DBClient) CompleteJob(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'done', error = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1;
+	`, id)
+
+	return err
+}
+
+// FailJob records the error of the job, and puts it back in the queue until it failed
+// maxAttempts times. The retry waits retryDelay, doubled on every failed attempt. Returns the
+// new status of the job.
+func (db *DBClient) FailJob(ctx context.Context, id int64, message string, maxAttempts int, retryDelay time.Duration) (string, error) {
+	var status string
+
+	err := db.Pool.QueryRow(ctx, `
+		UPDATE jobs
+		SET attempts = attempts + 1,
+			error = $2,
+			status = CASE WHEN attempts + 1 < $3 THEN 'pending' ELSE 'failed' END,
+			finished_at = CASE WHEN attempts + 1 < $3 THEN NULL ELSE NOW() END,
+			run_after = NOW() + make_interval(secs => $4 * power(2, attempts)),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING status;
+	`, id, message, maxAttempts, retryDelay.Seconds()).Scan(&status)
+
+	return status, err
+}
+
+// ReleaseJob puts a running job back in the queue without counting it as a failure, e.g.
+// when the worker shuts down.
+func (db *DBClient) ReleaseJob(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'pending', updated_at = NOW()
+		WHERE id = $1 AND status = 'running';
+	`, id)
+
+	return err
+}
+
+func (db *DBClient) GetJob(ctx context.Context, id int64) (Job, bool, error) {
+	job, err := scanJob(db.Pool.QueryRow(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE id = $1;
+	`, id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+
+	return job, true, nil
+}
+
+// GetJobs lists the jobs, newest first.
+func (db *DBClient) GetJobs(ctx context.Context, filter JobFilter) ([]Job, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE $1 = '' OR status = $1
+		ORDER BY id DESC
+		LIMIT $2;
+	`, filter.Status, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanJobs(rows)
+}
@@ -0,0 +1,833 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+)
+
+// MemoryStore is a Store that keeps everything in maps, meant for tests. It follows the same
+// rules as the Postgres queries, but nothing survives the process.
+type MemoryStore struct {
+	mu             sync.RWMutex
+	transactions   map[string]Transaction
+	blocks         map[uint64]Block
+	addressBlocks  map[string]map[uint64]bool
+	checkpoints    map[string]uint64
+	tokenTransfers map[logKey]TokenTransfer
+	nftTransfers   map[logKey]NFTTransfer
+	tokenMetadata  map[string]TokenMetadata
+	tracked        map[string]TrackedAddress
+	jobs           map[int64]Job
+	lastJobID      int64
+}
+
+// logKey identifies an event, BatchIndex is only used by NFT transfers.
+type logKey struct {
+	TransactionHash string
+	LogIndex        uint64
+	BatchIndex      uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		transactions:   map[string]Transaction{},
+		blocks:         map[uint64]Block{},
+		addressBlocks:  map[string]map[uint64]bool{},
+		checkpoints:    map[string]uint64{},
+		tokenTransfers: map[logKey]TokenTransfer{},
+		nftTransfers:   map[logKey]NFTTransfer{},
+		tokenMetadata:  map[string]TokenMetadata{},
+		tracked:        map[string]TrackedAddress{},
+		jobs:           map[int64]Job{},
+	}
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) Close() {}
+
+func (m *MemoryStore) UpsertTransactions(ctx context.Context, txs []Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tx := range txs {
+		m.transactions[tx.Hash] = tx
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) CommitBlock(ctx context.Context, job string, indexed IndexedBlock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tx := range indexed.Transactions {
+		m.transactions[tx.Hash] = tx
+	}
+	for _, t := range indexed.TokenTransfers {
+		m.tokenTransfers[logKey{t.TransactionHash, t.LogIndex, 0}] = t
+	}
+	for _, t := range indexed.NFTTransfers {
+		m.nftTransfers[logKey{t.TransactionHash, t.LogIndex, t.BatchIndex}] = t
+	}
+	m.blocks[indexed.Block.Number] = indexed.Block
+	for _, address := range indexed.Accounts {
+		if m.addressBlocks[address] == nil {
+			m.addressBlocks[address] = map[uint64]bool{}
+		}
+		m.addressBlocks[address][indexed.Block.Number] = true
+	}
+
+	if job != "" {
+		m.checkpoints[job] = indexed.Block.Number
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) RollbackBlocks(ctx context.Context, job string, fromBlock uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, tx := range m.transactions {
+		if tx.BlockNumber >= fromBlock {
+			delete(m.transactions, hash)
+		}
+	}
+	for key, t := range m.tokenTransfers {
+		if t.BlockNumber >= fromBlock {
+			delete(m.tokenTransfers, key)
+		}
+	}
+	for key, t := range m.nftTransfers {
+		if t.BlockNumber >= fromBlock {
+			delete(m.nftTransfers, key)
+		}
+	}
+	for number := range m.blocks {
+		if number >= fromBlock {
+			delete(m.blocks, number)
+		}
+	}
+	for _, numbers := range m.addressBlocks {
+		for number := range numbers {
+			if number >= fromBlock {
+				delete(numbers, number)
+			}
+		}
+	}
+
+	if fromBlock > 0 {
+		m.checkpoints[job] = fromBlock - 1
+	} else {
+		delete(m.checkpoints, job)
+	}
+
+	ids := []int64{}
+	for id, j := range m.jobs {
+		if j.ToBlock != nil && *j.ToBlock >= fromBlock {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		m.rollbackJob(m.jobs[id], fromBlock)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) rollbackJob(job Job, fromBlock uint64) {
+	if job.Status == JobRunning {
+		m.enqueueJob(rollbackReindex(job, fromBlock))
+		return
+	}
+
+	job.Progress = rewoundProgress(job, fromBlock)
+	if job.Status == JobDone {
+		job.Status = JobPending
+		job.FinishedAt = nil
+	}
+	job.UpdatedAt = time.Now().UTC()
+	m.jobs[job.ID] = job
+
+	checkpoint := JobCheckpoint(job.ID)
+	if fromBlock <= job.FromBlock {
+		delete(m.checkpoints, checkpoint)
+	} else if last, found := m.checkpoints[checkpoint]; found && last >= fromBlock {
+		m.checkpoints[checkpoint] = fromBlock - 1
+	}
+}
+
+func (m *MemoryStore) GetBlock(ctx context.Context, number uint64) (Block, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	block, found := m.blocks[number]
+	return block, found, nil
+}
+
+func (m *MemoryStore) GetCheckpoint(ctx context.Context, job string) (uint64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	lastBlock, found := m.checkpoints[job]
+	return lastBlock, found, nil
+}
+
+func (m *MemoryStore) GetLastIndexedBlock(ctx context.Context) (uint64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var last uint64
+	found := false
+	for number := range m.blocks {
+		if !found || number > last {
+			last = number
+			found = true
+		}
+	}
+
+	return last, found, nil
+}
+
+func (m *MemoryStore) GetFirstIndexedBlock(ctx context.Context, address string) (uint64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var first uint64
+	found := false
+	for number := range m.addressBlocks[address] {
+		if !found || number < first {
+			first = number
+			found = true
+		}
+	}
+
+	return first, found, nil
+}
+
+func (m *MemoryStore) GetMissingBlockRanges(ctx context.Context, address string, from, to uint64) ([]BlockRange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	numbers := []uint64{}
+	for number := range m.addressBlocks[address] {
+		if number >= from && number <= to {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	ranges := []BlockRange{}
+	next := from
+	for _, number := range numbers {
+		if number > next {
+			ranges = append(ranges, BlockRange{From: next, To: number - 1})
+		}
+		next = number + 1
+	}
+	if next <= to {
+		ranges = append(ranges, BlockRange{From: next, To: to})
+	}
+
+	return ranges, nil
+}
+
+// balanceDelta is the Go version of balanceDeltaSQL.
+func balanceDelta(tx Transaction, address string) decimal.Decimal {
+	switch {
+	case tx.Type == "fee" && tx.From == address:
+		return tx.Value.Neg()
+	case tx.Succesful && tx.From == address:
+		return tx.Value.Neg()
+	case tx.Succesful && tx.To == address:
+		return tx.Value
+	default:
+		return decimal.Zero
+	}
+}
+
+func (m *MemoryStore) GetBalance(ctx context.Context, address string) (GetBalanceResult, error) {
+	return m.GetBalanceAsOf(ctx, address, BalanceAsOf{})
+}
+
+func (m *MemoryStore) GetBalanceAsOf(ctx context.Context, address string, asOf BalanceAsOf) (GetBalanceResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := GetBalanceResult{Balance: decimal.Zero}
+	for _, tx := range m.transactions {
+		if tx.From != address && tx.To != address {
+			continue
+		}
+		if asOf.Block != nil && tx.BlockNumber > *asOf.Block {
+			continue
+		}
+		if asOf.Time != nil && tx.Timestamp.After(*asOf.Time) {
+			continue
+		}
+
+		result.Balance = result.Balance.Add(balanceDelta(tx, address))
+		result.Transactions++
+	}
+
+	return result, nil
+}
+
+// truncateTime mirrors date_trunc in UTC, weeks start on Monday.
+func truncateTime(t time.Time, interval string) (time.Time, error) {
+	t = t.UTC()
+
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour), nil
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		sinceMonday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -sinceMonday), nil
+	default:
+		return time.Time{}, fmt.Errorf("unsupported interval %q", interval)
+	}
+}
+
+func nextBucket(t time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return t.Add(time.Hour)
+	case "day":
+		return t.AddDate(0, 0, 1)
+	default:
+		return t.AddDate(0, 0, 7)
+	}
+}
+
+func (m *MemoryStore) GetBalanceHistory(ctx context.Context, address, interval string, start, end time.Time) ([]BalancePoint, error) {
+	first, err := truncateTime(start, interval)
+	if err != nil {
+		return nil, err
+	}
+	last, err := truncateTime(end, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	opening := decimal.Zero
+	changes := map[time.Time]decimal.Decimal{}
+	for _, tx := range m.transactions {
+		if (tx.From != address && tx.To != address) || tx.Timestamp.After(end) {
+			continue
+		}
+
+		bucket, _ := truncateTime(tx.Timestamp, interval)
+		delta := balanceDelta(tx, address)
+		if bucket.Before(first) {
+			opening = opening.Add(delta)
+		} else {
+			changes[bucket] = changes[bucket].Add(delta)
+		}
+	}
+
+	points := []BalancePoint{}
+	balance := opening
+	for bucket := first; !bucket.After(last); bucket = nextBucket(bucket, interval) {
+		change := changes[bucket]
+		balance = balance.Add(change)
+		points = append(points, BalancePoint{Bucket: bucket, Change: change, Balance: balance})
+	}
+
+	return points, nil
+}
+
+func (m *MemoryStore) GetTransactionsFromAddress(ctx context.Context, address string, filter TransactionFilter) (TransactionPage, error) {
+	return m.listTransactions(func(tx Transaction) bool {
+		switch filter.Direction {
+		case "in":
+			return tx.To == address && tx.Type != "fee"
+		case "out":
+			return tx.From == address
+		default:
+			return tx.From == address || tx.To == address
+		}
+	}, filter)
+}
+
+func (m *MemoryStore) GetTransactionsInRange(ctx context.Context, start, end time.Time, filter TransactionFilter) (TransactionPage, error) {
+	return m.listTransactions(func(tx Transaction) bool {
+		return !tx.Timestamp.Before(start) && !tx.Timestamp.After(end)
+	}, filter)
+}
+
+func (m *MemoryStore) listTransactions(match func(Transaction) bool, filter TransactionFilter) (TransactionPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	txs := []Transaction{}
+	for _, tx := range m.transactions {
+		if !match(tx) {
+			continue
+		}
+		if filter.Type != "" && tx.Type != filter.Type {
+			continue
+		}
+		if filter.Succesful != nil && tx.Succesful != *filter.Succesful {
+			continue
+		}
+		if filter.FromBlock != nil && tx.BlockNumber < *filter.FromBlock {
+			continue
+		}
+		if filter.ToBlock != nil && tx.BlockNumber > *filter.ToBlock {
+			continue
+		}
+		if filter.MinValue != nil && tx.Value.LessThan(*filter.MinValue) {
+			continue
+		}
+		if filter.Cursor != nil && !transactionBefore(tx, filter.Cursor.Timestamp, filter.Cursor.Hash) {
+			continue
+		}
+
+		tx.Timestamp = tx.Timestamp.UTC()
+		if block, err := data.NewHexFromString(tx.BlockIndex); err == nil {
+			tx.BlockIndex = block.Int.String()
+		}
+
+		txs = append(txs, tx)
+	}
+
+	// Newest first, same as ORDER BY timestamp DESC, hash DESC
+	sort.Slice(txs, func(i, j int) bool {
+		return transactionBefore(txs[j], txs[i].Timestamp, txs[i].Hash)
+	})
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	page := TransactionPage{Transactions: txs}
+	if len(txs) > limit {
+		page.Transactions = txs[:limit]
+		last := page.Transactions[limit-1]
+		page.Next = &TransactionCursor{Timestamp: last.Timestamp, Hash: last.Hash}
+	}
+
+	return page, nil
+}
+
+// transactionBefore is (tx.timestamp, tx.hash) < (timestamp, hash).
+func transactionBefore(tx Transaction, timestamp time.Time, hash string) bool {
+	if !tx.Timestamp.Equal(timestamp) {
+		return tx.Timestamp.Before(timestamp)
+	}
+	return tx.Hash < hash
+}
+
+func (m *MemoryStore) GetTokenTransfersFromAddress(ctx context.Context, address, token string) ([]TokenTransfer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	transfers := []TokenTransfer{}
+	for _, t := range m.tokenTransfers {
+		if t.From != address && t.To != address {
+			continue
+		}
+		if token != "" && t.Token != token {
+			continue
+		}
+
+		t.BlockIndex = strconv.FormatUint(t.BlockNumber, 10)
+		t.Timestamp = t.Timestamp.UTC()
+		transfers = append(transfers, t)
+	}
+
+	sort.Slice(transfers, func(i, j int) bool {
+		if transfers[i].BlockNumber != transfers[j].BlockNumber {
+			return transfers[i].BlockNumber > transfers[j].BlockNumber
+		}
+		return transfers[i].LogIndex > transfers[j].LogIndex
+	})
+
+	return transfers, nil
+}
+
+func (m *MemoryStore) GetTokenBalances(ctx context.Context, address string) ([]TokenBalance, error) {
+	return m.getTokenBalances(address, "")
+}
+
+func (m *MemoryStore) GetTokenBalance(ctx context.Context, address, token string) (TokenBalance, error) {
+	balances, err := m.getTokenBalances(address, token)
+	if err != nil {
+		return TokenBalance{}, err
+	}
+
+	if len(balances) == 0 {
+		return TokenBalance{Token: token}, nil
+	}
+
+	return balances[0], nil
+}
+
+func (m *MemoryStore) getTokenBalances(address, token string) ([]TokenBalance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byToken := map[string]*TokenBalance{}
+	for _, t := range m.tokenTransfers {
+		if t.From != address && t.To != address {
+			continue
+		}
+		if token != "" && t.Token != token {
+			continue
+		}
+
+		b, ok := byToken[t.Token]
+		if !ok {
+			b = &TokenBalance{Token: t.Token, Balance: decimal.Zero}
+			if metadata, found := m.tokenMetadata[t.Token]; found {
+				b.Metadata = &metadata
+			}
+			byToken[t.Token] = b
+		}
+
+		if t.To == address {
+			b.Balance = b.Balance.Add(t.Value)
+		}
+		if t.From == address {
+			b.Balance = b.Balance.Sub(t.Value)
+		}
+		b.Transfers++
+	}
+
+	balances := make([]TokenBalance, 0, len(byToken))
+	for _, b := range byToken {
+		balances = append(balances, *b)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Token < balances[j].Token })
+
+	return balances, nil
+}
+
+func (m *MemoryStore) UpsertTokenMetadata(ctx context.Context, metadata TokenMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokenMetadata[metadata.Token] = metadata
+
+	return nil
+}
+
+func (m *MemoryStore) GetNFTHoldings(ctx context.Context, address string) ([]NFTHolding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type tokenKey struct {
+		contract, standard, tokenID string
+	}
+
+	byToken := map[tokenKey]*NFTHolding{}
+	for _, t := range m.nftTransfers {
+		if t.From != address && t.To != address {
+			continue
+		}
+
+		key := tokenKey{t.Contract, t.Standard, t.TokenID.String()}
+		h, ok := byToken[key]
+		if !ok {
+			h = &NFTHolding{Contract: t.Contract, Standard: t.Standard, TokenID: t.TokenID, Amount: decimal.Zero}
+			byToken[key] = h
+		}
+
+		if t.To == address {
+			h.Amount = h.Amount.Add(t.Amount)
+		}
+		if t.From == address {
+			h.Amount = h.Amount.Sub(t.Amount)
+		}
+	}
+
+	holdings := []NFTHolding{}
+	for _, h := range byToken {
+		if h.Amount.IsPositive() {
+			holdings = append(holdings, *h)
+		}
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].Contract != holdings[j].Contract {
+			return holdings[i].Contract < holdings[j].Contract
+		}
+		return holdings[i].TokenID.LessThan(holdings[j].TokenID)
+	})
+
+	return holdings, nil
+}
+
+func (m *MemoryStore) GetNFTTransfersFromAddress(ctx context.Context, address string) ([]NFTTransfer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	transfers := []NFTTransfer{}
+	for _, t := range m.nftTransfers {
+		if t.From != address && t.To != address {
+			continue
+		}
+
+		t.BlockIndex = strconv.FormatUint(t.BlockNumber, 10)
+		t.Timestamp = t.Timestamp.UTC()
+		transfers = append(transfers, t)
+	}
+
+	sort.Slice(transfers, func(i, j int) bool {
+		a, b := transfers[i], transfers[j]
+		if a.BlockNumber != b.BlockNumber {
+			return a.BlockNumber > b.BlockNumber
+		}
+		if a.LogIndex != b.LogIndex {
+			return a.LogIndex > b.LogIndex
+		}
+		return a.BatchIndex > b.BatchIndex
+	})
+
+	return transfers, nil
+}
+
+func (m *MemoryStore) AddTrackedAddress(ctx context.Context, address string, startBlock *uint64) (TrackedAddress, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.tracked[address]; found {
+		return TrackedAddress{}, false, nil
+	}
+
+	tracked := TrackedAddress{
+		Address:    address,
+		StartBlock: startBlock,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if startBlock != nil {
+		job := m.enqueueJob(Job{Kind: JobKindBackfill, Address: &address, FromBlock: *startBlock})
+		tracked.BackfillJob = &job.ID
+	}
+	m.tracked[address] = tracked
+
+	return tracked, true, nil
+}
+
+func (m *MemoryStore) GetTrackedAddresses(ctx context.Context) ([]TrackedAddress, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tracked := make([]TrackedAddress, 0, len(m.tracked))
+	for _, t := range m.tracked {
+		tracked = append(tracked, t)
+	}
+	sort.Slice(tracked, func(i, j int) bool {
+		if !tracked[i].CreatedAt.Equal(tracked[j].CreatedAt) {
+			return tracked[i].CreatedAt.Before(tracked[j].CreatedAt)
+		}
+		return tracked[i].Address < tracked[j].Address
+	})
+
+	return tracked, nil
+}
+
+func (m *MemoryStore) enqueueJob(job Job) Job {
+	m.lastJobID++
+	now := time.Now().UTC()
+
+	job = Job{
+		ID:        m.lastJobID,
+		Kind:      job.Kind,
+		Address:   job.Address,
+		FromBlock: job.FromBlock,
+		ToBlock:   job.ToBlock,
+		Status:    JobPending,
+		RunAfter:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.jobs[job.ID] = job
+
+	return job
+}
+
+func (m *MemoryStore) EnqueueJob(ctx context.Context, job Job) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.enqueueJob(job), nil
+}
+
+func (m *MemoryStore) ScheduleBackfills(ctx context.Context, end uint64) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scheduled := []Job{}
+	for id, job := range m.jobs {
+		if job.Kind != JobKindBackfill || job.Status != JobPending || job.ToBlock != nil {
+			continue
+		}
+
+		job.ToBlock = &end
+		job.UpdatedAt = time.Now().UTC()
+		m.jobs[id] = job
+		scheduled = append(scheduled, job)
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].ID < scheduled[j].ID })
+
+	return scheduled, nil
+}
+
+func (m *MemoryStore) ClaimJob(ctx context.Context, staleAfter time.Duration) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	var claimed *Job
+	for _, job := range m.jobs {
+		runnable := job.Status == JobPending && job.ToBlock != nil && !job.RunAfter.After(now)
+		stale := job.Status == JobRunning && job.UpdatedAt.Before(now.Add(-staleAfter))
+		if (runnable || stale) && (claimed == nil || job.ID < claimed.ID) {
+			claimed = &job
+		}
+	}
+	if claimed == nil {
+		return Job{}, false, nil
+	}
+
+	claimed.Status = JobRunning
+	claimed.StartedAt = &now
+	claimed.UpdatedAt = now
+	m.jobs[claimed.ID] = *claimed
+
+	return *claimed, true, nil
+}
+
+func (m *MemoryStore) UpdateJobProgress(ctx context.Context, id int64, progress uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, found := m.jobs[id]; found {
+		job.Progress = progress
+		job.UpdatedAt = time.Now().UTC()
+		m.jobs[id] = job
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) HeartbeatJob(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, found := m.jobs[id]; found && job.Status == JobRunning {
+		job.UpdatedAt = time.Now().UTC()
+		m.jobs[id] = job
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) CompleteJob(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, found := m.jobs[id]; found {
+		now := time.Now().UTC()
+		job.Status = JobDone
+		job.Error = nil
+		job.FinishedAt = &now
+		job.UpdatedAt = now
+		m.jobs[id] = job
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) FailJob(ctx context.Context, id int64, message string, maxAttempts int, retryDelay time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, found := m.jobs[id]
+	if !found {
+		return "", fmt.Errorf("job %d not found", id)
+	}
+
+	now := time.Now().UTC()
+	job.RunAfter = now.Add(retryDelay << job.Attempts)
+	job.Attempts++
+	job.Error = &message
+	job.UpdatedAt = now
+	if job.Attempts < maxAttempts {
+		job.Status = JobPending
+		job.FinishedAt = nil
+	} else {
+		job.Status = JobFailed
+		job.FinishedAt = &now
+	}
+	m.jobs[id] = job
+
+	return job.Status, nil
+}
+
+func (m *MemoryStore) ReleaseJob(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, found := m.jobs[id]; found && job.Status == JobRunning {
+		job.Status = JobPending
+		job.UpdatedAt = time.Now().UTC()
+		m.jobs[id] = job
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) GetJob(ctx context.Context, id int64) (Job, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, found := m.jobs[id]
+	return job, found, nil
+}
+
+func (m *MemoryStore) GetJobs(ctx context.Context, filter JobFilter) ([]Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	jobs := []Job{}
+	for _, job := range m.jobs {
+		if filter.Status == "" || job.Status == filter.Status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID > jobs[j].ID })
+
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	return jobs, nil
+}
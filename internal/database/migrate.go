@@ -0,0 +1,272 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Migrations live in migrations/ as <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Versions must be unique and are applied in increasing order. Never edit a migration that
+// was already released, add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key for the advisory lock that keeps two migrators from running at the same time.
+const migrationLockKey = 7_214_005_311
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a known migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: must end in .up.sql or .down.sql", file)
+		}
+
+		versionStr, name, found := strings.Cut(strings.TrimSuffix(file, "."+direction+".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s: must be named <version>_<name>", file)
+		}
+
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", file, err)
+		}
+
+		sql, err := fs.ReadFile(migrationFiles, path.Join("migrations", file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up migration", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (db *DBClient) ensureMigrationsTable(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+
+	return err
+}
+
+func (db *DBClient) appliedMigrations(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// Migrate applies every pending migration, each in its own transaction, and returns the ones it applied.
+func (db *DBClient) Migrate(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	applied := []Migration{}
+	for _, m := range migrations {
+		ok, err := db.runMigration(ctx, m, true)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+			applied = append(applied, m)
+		}
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns the ones it reverted.
+func (db *DBClient) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return reverted, fmt.Errorf("migration %d_%s: missing down migration", m.Version, m.Name)
+		}
+
+		ok, err := db.runMigration(ctx, m, false)
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+			reverted = append(reverted, m)
+		}
+	}
+
+	return reverted, nil
+}
+
+// runMigration applies or reverts a migration in a transaction holding the migration lock.
+// Returns false if someone else already did it in the meantime.
+func (db *DBClient) runMigration(ctx context.Context, m Migration, up bool) (bool, error) {
+	dbTx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer dbTx.Rollback(ctx)
+
+	if _, err := dbTx.Exec(ctx, `SELECT pg_advisory_xact_lock($1);`, migrationLockKey); err != nil {
+		return false, fmt.Errorf("acquiring migration lock: %w", err)
+	}
+
+	var applied bool
+	err = dbTx.QueryRow(ctx, `SELECT true FROM schema_migrations WHERE version = $1;`, m.Version).Scan(&applied)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+	if applied == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := dbTx.Exec(ctx, m.Up); err != nil {
+			return false, err
+		}
+		if _, err := dbTx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`, m.Version, m.Name); err != nil {
+			return false, err
+		}
+	} else {
+		if _, err := dbTx.Exec(ctx, m.Down); err != nil {
+			return false, err
+		}
+		if _, err := dbTx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1;`, m.Version); err != nil {
+			return false, err
+		}
+	}
+
+	return true, dbTx.Commit(ctx)
+}
+
+// MigrationStatus lists every known migration, oldest first, with when it was applied.
+func (db *DBClient) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
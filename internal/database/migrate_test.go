@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+// baselineSchema is what CreateSchema created before the migrations, without block numbers.
+const baselineSchema = `
+CREATE TABLE transactions (
+	hash TEXT PRIMARY KEY,
+	type TEXT NOT NULL CHECK (type IN ('transfer', 'call', 'fee')),
+	value NUMERIC NOT NULL,
+	from_address TEXT NOT NULL,
+	to_address TEXT NOT NULL,
+	block_index TEXT NOT NULL,
+	succesful BOOLEAN NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_from ON transactions(from_address);
+CREATE INDEX IF NOT EXISTS idx_transactions_to ON transactions(to_address);
+CREATE INDEX IF NOT EXISTS idx_transactions_from_timestamp ON transactions(from_address, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_transactions_to_timestamp ON transactions(to_address, timestamp DESC);
+`
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %d has version %d, versions must follow each other", i, m.Version)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("migration %d_%s is missing its up or down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateFromBaselineSchema(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	if _, err := db.Pool.Exec(ctx, baselineSchema); err != nil {
+		t.Fatalf("creating baseline schema: %v", err)
+	}
+	if _, err := db.Pool.Exec(ctx, `
+		INSERT INTO transactions (hash, type, value, from_address, to_address, block_index, succesful, timestamp)
+		VALUES ('0xaa', 'transfer', 1000, '0x01', '0x02', '0x1d7c0b3', true, NOW());
+	`); err != nil {
+		t.Fatalf("inserting baseline transaction: %v", err)
+	}
+
+	applied, err := db.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	migrations, _ := loadMigrations()
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+
+	var blockNumber uint64
+	if err := db.Pool.QueryRow(ctx, `SELECT block_number FROM transactions WHERE hash = '0xaa';`).Scan(&blockNumber); err != nil {
+		t.Fatalf("reading block_number: %v", err)
+	}
+	if blockNumber != 0x1d7c0b3 {
+		t.Errorf("block_number = %d, want %d", blockNumber, 0x1d7c0b3)
+	}
+
+	// New rows can't leave it out
+	if _, err := db.Pool.Exec(ctx, `
+		INSERT INTO transactions (hash, type, value, from_address, to_address, block_index, succesful, timestamp)
+		VALUES ('0xbb', 'transfer', 1000, '0x01', '0x02', '0x1', true, NOW());
+	`); err == nil {
+		t.Error("inserted a transaction without block_number")
+	}
+
+	// The tables CreateSchema never had are created too
+	for _, table := range []string{"blocks", "indexer_state", "token_transfers", "token_metadata", "nft_transfers", "tracked_addresses", "jobs", "address_blocks"} {
+		var exists bool
+		if err := db.Pool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, table).Scan(&exists); err != nil || !exists {
+			t.Errorf("table %s wasn't created: %v", table, err)
+		}
+	}
+
+	// And the migrations can be rolled back all the way
+	if _, err := db.MigrateDown(ctx, len(migrations)); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+}
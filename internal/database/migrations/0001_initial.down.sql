@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS nft_transfers;
+DROP TABLE IF EXISTS token_metadata;
+DROP TABLE IF EXISTS token_transfers;
+DROP TABLE IF EXISTS indexer_state;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS transactions;
@@ -0,0 +1,83 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created with the old CreateSchema can adopt it.
+CREATE TABLE IF NOT EXISTS transactions (
+	hash TEXT PRIMARY KEY,
+	type TEXT NOT NULL CHECK (type IN ('transfer', 'call', 'fee')),
+	value NUMERIC NOT NULL,
+	from_address TEXT NOT NULL,
+	to_address TEXT NOT NULL,
+	block_index TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	succesful BOOLEAN NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL
+);
+
+-- Databases created before the reorg handling have no block_number, derive it from the hex block_index
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS block_number BIGINT;
+UPDATE transactions
+SET block_number = ('x' || lpad(substr(block_index, 3), 16, '0'))::bit(64)::bigint
+WHERE block_number IS NULL;
+ALTER TABLE transactions ALTER COLUMN block_number SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_from ON transactions(from_address);
+CREATE INDEX IF NOT EXISTS idx_transactions_to ON transactions(to_address);
+CREATE INDEX IF NOT EXISTS idx_transactions_from_timestamp ON transactions(from_address, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_transactions_to_timestamp ON transactions(to_address, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_transactions_block_number ON transactions(block_number);
+
+CREATE TABLE IF NOT EXISTS blocks (
+	number BIGINT PRIMARY KEY,
+	hash TEXT NOT NULL,
+	parent_hash TEXT NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS indexer_state (
+	job TEXT PRIMARY KEY,
+	last_block BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS token_transfers (
+	tx_hash TEXT NOT NULL,
+	log_index BIGINT NOT NULL,
+	token_address TEXT NOT NULL,
+	from_address TEXT NOT NULL,
+	to_address TEXT NOT NULL,
+	value NUMERIC NOT NULL,
+	block_index TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (tx_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_transfers_from ON token_transfers(from_address, token_address);
+CREATE INDEX IF NOT EXISTS idx_token_transfers_to ON token_transfers(to_address, token_address);
+CREATE INDEX IF NOT EXISTS idx_token_transfers_block_number ON token_transfers(block_number);
+
+CREATE TABLE IF NOT EXISTS token_metadata (
+	token_address TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	symbol TEXT NOT NULL,
+	decimals INTEGER NULL,
+	fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS nft_transfers (
+	tx_hash TEXT NOT NULL,
+	log_index BIGINT NOT NULL,
+	batch_index BIGINT NOT NULL,
+	contract_address TEXT NOT NULL,
+	standard TEXT NOT NULL CHECK (standard IN ('erc721', 'erc1155')),
+	token_id NUMERIC NOT NULL,
+	amount NUMERIC NOT NULL,
+	from_address TEXT NOT NULL,
+	to_address TEXT NOT NULL,
+	block_index TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (tx_hash, log_index, batch_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_nft_transfers_from ON nft_transfers(from_address, contract_address);
+CREATE INDEX IF NOT EXISTS idx_nft_transfers_to ON nft_transfers(to_address, contract_address);
+CREATE INDEX IF NOT EXISTS idx_nft_transfers_block_number ON nft_transfers(block_number);
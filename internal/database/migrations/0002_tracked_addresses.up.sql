@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS tracked_addresses (
+	address TEXT PRIMARY KEY,
+	-- First block to backfill, NULL if only new blocks are indexed
+	start_block BIGINT NULL,
+	-- Last block to backfill, set by the follower when it starts indexing the address
+	backfill_end BIGINT NULL,
+	backfilled_at TIMESTAMPTZ NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,13 @@
+ALTER TABLE tracked_addresses
+	ADD COLUMN IF NOT EXISTS backfill_end BIGINT NULL,
+	ADD COLUMN IF NOT EXISTS backfilled_at TIMESTAMPTZ NULL;
+
+UPDATE tracked_addresses t
+SET backfill_end = j.to_block,
+	backfilled_at = CASE WHEN j.status = 'done' THEN j.finished_at END
+FROM jobs j
+WHERE j.id = t.backfill_job;
+
+ALTER TABLE tracked_addresses DROP COLUMN IF EXISTS backfill_job;
+
+DROP TABLE IF EXISTS jobs;
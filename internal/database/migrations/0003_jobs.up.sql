@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL CHECK (kind IN ('backfill', 'reindex')),
+	-- Only this address is indexed, NULL means every indexed address
+	address TEXT NULL,
+	from_block BIGINT NOT NULL,
+	-- NULL while a backfill waits for the follower to pick the address up
+	to_block BIGINT NULL,
+	status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+	-- Blocks processed so far
+	progress BIGINT NOT NULL DEFAULT 0,
+	error TEXT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	started_at TIMESTAMPTZ NULL,
+	finished_at TIMESTAMPTZ NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, id);
+
+ALTER TABLE tracked_addresses ADD COLUMN IF NOT EXISTS backfill_job BIGINT NULL REFERENCES jobs(id);
+
+-- Backfills that didn't finish move to the queue, they start over under the checkpoint of the job
+INSERT INTO jobs (kind, address, from_block, to_block)
+SELECT 'backfill', address, start_block, backfill_end
+FROM tracked_addresses
+WHERE start_block IS NOT NULL AND backfilled_at IS NULL
+ORDER BY created_at, address;
+
+UPDATE tracked_addresses t
+SET backfill_job = j.id
+FROM jobs j
+WHERE j.kind = 'backfill' AND j.address = t.address;
+
+ALTER TABLE tracked_addresses DROP COLUMN backfill_end, DROP COLUMN backfilled_at;
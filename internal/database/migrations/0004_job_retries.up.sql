@@ -0,0 +1,2 @@
+-- A failed job waits before it is claimed again, longer after every failed attempt
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS run_after TIMESTAMPTZ NOT NULL DEFAULT NOW();
@@ -0,0 +1,10 @@
+-- Blocks indexed for each address. The blocks table has every block indexed for any of them,
+-- e.g. a backfill of a single address adds blocks none of the others were indexed at. The blocks
+-- indexed before this migration aren't known, verify only checks the ones indexed after it
+CREATE TABLE IF NOT EXISTS address_blocks (
+	address TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	PRIMARY KEY (address, block_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_address_blocks_block_number ON address_blocks(block_number);
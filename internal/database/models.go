@@ -7,12 +7,141 @@ import (
 )
 
 type Transaction struct {
-	Hash       string          `db:"hash" json:"hash"` // Primary key
-	Type       string          `db:"type" json:"type"` // "transfer", "call" or "fee", with more time I would make it an enum type
-	Value      decimal.Decimal `db:"value" json:"value"`
-	From       string          `db:"from_address" json:"from"`
-	To         string          `db:"to_address" json:"to"`
-	BlockIndex string          `db:"block_index" json:"blockIndex"`
-	Succesful  bool            `db:"succesful" json:"susccesful"`
-	Timestamp  time.Time       `db:"timestamp" json:"timestamp"` // For range queries
+	Hash        string          `db:"hash" json:"hash"` // Primary key
+	Type        string          `db:"type" json:"type"` // "transfer", "call" or "fee", with more time I would make it an enum type
+	Value       decimal.Decimal `db:"value" json:"value"`
+	From        string          `db:"from_address" json:"from"`
+	To          string          `db:"to_address" json:"to"`
+	BlockIndex  string          `db:"block_index" json:"blockIndex"`
+	BlockNumber uint64          `db:"block_number" json:"-"` // Same as BlockIndex, but numeric so we can roll back blocks
+	Succesful   bool            `db:"succesful" json:"susccesful"`
+	Timestamp   time.Time       `db:"timestamp" json:"timestamp"` // For range queries
+}
+
+type Block struct {
+	Number     uint64    `db:"number" json:"number"`
+	Hash       string    `db:"hash" json:"hash"`
+	ParentHash string    `db:"parent_hash" json:"parentHash"`
+	Timestamp  time.Time `db:"timestamp" json:"timestamp"`
+}
+
+// TokenTransfer is an ERC-20 Transfer event involving one of the indexed addresses.
+type TokenTransfer struct {
+	TransactionHash string          `db:"tx_hash" json:"transactionHash"` // Primary key with LogIndex
+	LogIndex        uint64          `db:"log_index" json:"logIndex"`
+	Token           string          `db:"token_address" json:"token"` // Contract that emitted the event
+	From            string          `db:"from_address" json:"from"`
+	To              string          `db:"to_address" json:"to"`
+	Value           decimal.Decimal `db:"value" json:"value"` // Raw amount, not scaled by the decimals of the token
+	BlockIndex      string          `db:"block_index" json:"blockIndex"`
+	BlockNumber     uint64          `db:"block_number" json:"-"`
+	Timestamp       time.Time       `db:"timestamp" json:"timestamp"`
+}
+
+// IndexedBlock is everything the indexer extracted from a block, stored together.
+type IndexedBlock struct {
+	Block          Block
+	Transactions   []Transaction
+	TokenTransfers []TokenTransfer
+	NFTTransfers   []NFTTransfer
+	// Addresses the block was indexed for
+	Accounts []string
+}
+
+// TokenMetadata is fetched once from the token contract and cached.
+type TokenMetadata struct {
+	Token    string `db:"token_address" json:"token"`
+	Name     string `db:"name" json:"name"`
+	Symbol   string `db:"symbol" json:"symbol"`
+	Decimals *int32 `db:"decimals" json:"decimals"` // Nil if the token doesn't implement decimals()
+}
+
+// TokenBalance aggregates the indexed transfers of a token for an address.
+type TokenBalance struct {
+	Token     string
+	Balance   decimal.Decimal // Raw amount, not scaled by the decimals of the token
+	Transfers uint64
+	Metadata  *TokenMetadata // Nil if it wasn't fetched yet
+}
+
+// NFTTransfer is an ERC-721 Transfer or ERC-1155 TransferSingle/TransferBatch event involving one
+// of the indexed addresses. Every token of a TransferBatch is stored as its own row.
+type NFTTransfer struct {
+	TransactionHash string          `db:"tx_hash" json:"transactionHash"` // Primary key with LogIndex and BatchIndex
+	LogIndex        uint64          `db:"log_index" json:"logIndex"`
+	BatchIndex      uint64          `db:"batch_index" json:"batchIndex"` // Position in a TransferBatch, 0 otherwise
+	Contract        string          `db:"contract_address" json:"contract"`
+	Standard        string          `db:"standard" json:"standard"` // "erc721" or "erc1155"
+	TokenID         decimal.Decimal `db:"token_id" json:"tokenId"`
+	Amount          decimal.Decimal `db:"amount" json:"amount"` // Always 1 for ERC-721
+	From            string          `db:"from_address" json:"from"`
+	To              string          `db:"to_address" json:"to"`
+	BlockIndex      string          `db:"block_index" json:"blockIndex"`
+	BlockNumber     uint64          `db:"block_number" json:"-"`
+	Timestamp       time.Time       `db:"timestamp" json:"timestamp"`
+}
+
+// NFTHolding is a token currently owned by an address, according to the indexed transfers.
+type NFTHolding struct {
+	Contract string          `json:"contract"`
+	Standard string          `json:"standard"`
+	TokenID  decimal.Decimal `json:"tokenId"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// BalancePoint is the balance of an address at the end of a time bucket.
+type BalancePoint struct {
+	Bucket  time.Time       `json:"bucket"` // Start of the bucket
+	Change  decimal.Decimal `json:"change"` // Net movement within the bucket
+	Balance decimal.Decimal `json:"balance"`
+}
+
+// BlockRange is an inclusive range of block numbers.
+type BlockRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// TrackedAddress is an address registered through the API, indexed on top of ADDRESSES.
+type TrackedAddress struct {
+	Address     string    `db:"address" json:"address"`
+	StartBlock  *uint64   `db:"start_block" json:"startBlock"`   // Nil if there is no history to backfill
+	BackfillJob *int64    `db:"backfill_job" json:"backfillJob"` // Job backfilling the history, nil without StartBlock
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
+}
+
+const (
+	// Index the history of a tracked address, up to where the follower picked it up
+	JobKindBackfill = "backfill"
+	// Index a range of blocks again
+	JobKindReindex = "reindex"
+
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a range of blocks to index, queued in the jobs table and run by the indexer workers.
+type Job struct {
+	ID         int64      `db:"id" json:"id"`
+	Kind       string     `db:"kind" json:"kind"`
+	Address    *string    `db:"address" json:"address"` // Nil if the job covers every indexed address
+	FromBlock  uint64     `db:"from_block" json:"fromBlock"`
+	ToBlock    *uint64    `db:"to_block" json:"toBlock"` // Nil while a backfill waits for the follower
+	Status     string     `db:"status" json:"status"`
+	Progress   uint64     `db:"progress" json:"progress"`  // Blocks processed so far
+	Error      *string    `db:"error" json:"error"`        // Last error, kept while the job is retried
+	Attempts   int        `db:"attempts" json:"attempts"`  // Failed attempts
+	RunAfter   time.Time  `db:"run_after" json:"runAfter"` // Not claimed before, to back off after a failure
+	CreatedAt  time.Time  `db:"created_at" json:"createdAt"`
+	StartedAt  *time.Time `db:"started_at" json:"startedAt"`
+	FinishedAt *time.Time `db:"finished_at" json:"finishedAt"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+// JobFilter narrows down the jobs listed, newest first.
+type JobFilter struct {
+	Status string // Any status if empty
+	Limit  int    // Defaults to DefaultPageSize, capped at MaxPageSize
 }
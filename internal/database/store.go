@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Store is everything the indexer and the API need from the storage. DBClient is the Postgres
+// backend, MemoryStore keeps everything in memory for tests.
+type Store interface {
+	Ping(ctx context.Context) error
+	Close()
+
+	// Indexing
+	UpsertTransactions(ctx context.Context, txs []Transaction) error
+	CommitBlock(ctx context.Context, job string, indexed IndexedBlock) error
+	RollbackBlocks(ctx context.Context, job string, fromBlock uint64) error
+	GetBlock(ctx context.Context, number uint64) (Block, bool, error)
+	GetCheckpoint(ctx context.Context, job string) (uint64, bool, error)
+	GetLastIndexedBlock(ctx context.Context) (uint64, bool, error)
+	GetFirstIndexedBlock(ctx context.Context, address string) (uint64, bool, error)
+	GetMissingBlockRanges(ctx context.Context, address string, from, to uint64) ([]BlockRange, error)
+
+	// Native balance and transactions
+	GetBalance(ctx context.Context, address string) (GetBalanceResult, error)
+	GetBalanceAsOf(ctx context.Context, address string, asOf BalanceAsOf) (GetBalanceResult, error)
+	GetBalanceHistory(ctx context.Context, address, interval string, start, end time.Time) ([]BalancePoint, error)
+	GetTransactionsFromAddress(ctx context.Context, address string, filter TransactionFilter) (TransactionPage, error)
+	GetTransactionsInRange(ctx context.Context, start, end time.Time, filter TransactionFilter) (TransactionPage, error)
+
+	// Tokens
+	GetTokenTransfersFromAddress(ctx context.Context, address, token string) ([]TokenTransfer, error)
+	GetTokenBalances(ctx context.Context, address string) ([]TokenBalance, error)
+	GetTokenBalance(ctx context.Context, address, token string) (TokenBalance, error)
+	UpsertTokenMetadata(ctx context.Context, metadata TokenMetadata) error
+	GetNFTHoldings(ctx context.Context, address string) ([]NFTHolding, error)
+	GetNFTTransfersFromAddress(ctx context.Context, address string) ([]NFTTransfer, error)
+
+	// Addresses registered through the API
+	AddTrackedAddress(ctx context.Context, address string, startBlock *uint64) (TrackedAddress, bool, error)
+	GetTrackedAddresses(ctx context.Context) ([]TrackedAddress, error)
+
+	// Job queue
+	EnqueueJob(ctx context.Context, job Job) (Job, error)
+	ScheduleBackfills(ctx context.Context, end uint64) ([]Job, error)
+	ClaimJob(ctx context.Context, staleAfter time.Duration) (Job, bool, error)
+	UpdateJobProgress(ctx context.Context, id int64, progress uint64) error
+	HeartbeatJob(ctx context.Context, id int64) error
+	CompleteJob(ctx context.Context, id int64) error
+	FailJob(ctx context.Context, id int64, message string, maxAttempts int, retryDelay time.Duration) (string, error)
+	ReleaseJob(ctx context.Context, id int64) error
+	GetJob(ctx context.Context, id int64) (Job, bool, error)
+	GetJobs(ctx context.Context, filter JobFilter) ([]Job, error)
+}
+
+var (
+	_ Store = (*DBClient)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,580 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// forEachStore runs the test against MemoryStore, and against Postgres when TEST_DATABASE_URL
+// is set, so both backends behave the same.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("postgres", func(t *testing.T) {
+		db := testDB(t)
+		if _, err := db.Migrate(context.Background()); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+		test(t, db)
+	})
+}
+
+func enqueueRunnable(t *testing.T, store Store, from, to uint64) Job {
+	t.Helper()
+
+	job, err := store.EnqueueJob(context.Background(), Job{Kind: JobKindReindex, FromBlock: from, ToBlock: &to})
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	return job
+}
+
+func TestFailedJobBacksOff(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		job := enqueueRunnable(t, store, 10, 20)
+
+		claim := func() (Job, bool) {
+			t.Helper()
+			claimed, found, err := store.ClaimJob(ctx, time.Hour)
+			if err != nil {
+				t.Fatalf("ClaimJob: %v", err)
+			}
+			return claimed, found
+		}
+
+		if claimed, found := claim(); !found || claimed.ID != job.ID {
+			t.Fatalf("claimed %+v (found %t), want job %d", claimed, found, job.ID)
+		}
+
+		status, err := store.FailJob(ctx, job.ID, "boom", 3, time.Hour)
+		if err != nil {
+			t.Fatalf("FailJob: %v", err)
+		}
+		if status != JobPending {
+			t.Fatalf("status = %s after 1 of 3 attempts, want %s", status, JobPending)
+		}
+
+		failed, _, err := store.GetJob(ctx, job.ID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if wait := time.Until(failed.RunAfter); wait < 59*time.Minute || wait > time.Hour {
+			t.Errorf("run after = %s, want in an hour", failed.RunAfter)
+		}
+		if claimed, found := claim(); found {
+			t.Fatalf("claimed %+v while it waits for its retry", claimed)
+		}
+
+		// Without a delay it can be retried right away
+		other := enqueueRunnable(t, store, 30, 40)
+		if claimed, found := claim(); !found || claimed.ID != other.ID {
+			t.Fatalf("claimed %+v (found %t), want job %d", claimed, found, other.ID)
+		}
+		if _, err := store.FailJob(ctx, other.ID, "boom", 3, 0); err != nil {
+			t.Fatalf("FailJob: %v", err)
+		}
+		if claimed, found := claim(); !found || claimed.ID != other.ID || claimed.Attempts != 1 {
+			t.Fatalf("claimed %+v (found %t), want job %d after 1 attempt", claimed, found, other.ID)
+		}
+	})
+}
+
+func TestJobHeartbeat(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		job := enqueueRunnable(t, store, 10, 20)
+		staleAfter := 50 * time.Millisecond
+
+		if _, found, err := store.ClaimJob(ctx, staleAfter); err != nil || !found {
+			t.Fatalf("ClaimJob = %t, %v", found, err)
+		}
+
+		// Kept alive without any progress
+		for range 4 {
+			time.Sleep(staleAfter / 2)
+			if err := store.HeartbeatJob(ctx, job.ID); err != nil {
+				t.Fatalf("HeartbeatJob: %v", err)
+			}
+		}
+		if claimed, found, err := store.ClaimJob(ctx, staleAfter); err != nil || found {
+			t.Fatalf("ClaimJob = %+v, %t, %v, the job is alive", claimed, found, err)
+		}
+
+		// Abandoned
+		time.Sleep(2 * staleAfter)
+		claimed, found, err := store.ClaimJob(ctx, staleAfter)
+		if err != nil || !found || claimed.ID != job.ID {
+			t.Fatalf("ClaimJob = %+v, %t, %v, want the stale job %d", claimed, found, err, job.ID)
+		}
+	})
+}
+
+var (
+	alice   = "0x00000000000000000000000000000000000000a1"
+	bob     = "0x00000000000000000000000000000000000000b0"
+	carol   = "0x00000000000000000000000000000000000000c4"
+	genesis = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// testBlock is block number with the transactions, token transfers and NFT transfers placed in it.
+func testBlock(number uint64, txs []Transaction, tokens []TokenTransfer, nfts []NFTTransfer) IndexedBlock {
+	timestamp := genesis.Add(time.Duration(number) * 2 * time.Second)
+	blockIndex := fmt.Sprintf("0x%x", number)
+
+	for i := range txs {
+		txs[i].BlockIndex, txs[i].BlockNumber, txs[i].Timestamp = blockIndex, number, timestamp
+	}
+	for i := range tokens {
+		tokens[i].BlockIndex, tokens[i].BlockNumber, tokens[i].Timestamp = blockIndex, number, timestamp
+	}
+	for i := range nfts {
+		nfts[i].BlockIndex, nfts[i].BlockNumber, nfts[i].Timestamp = blockIndex, number, timestamp
+	}
+
+	return IndexedBlock{
+		Block:          Block{Number: number, Hash: fmt.Sprintf("0xb%d", number), ParentHash: fmt.Sprintf("0xb%d", number-1), Timestamp: timestamp},
+		Transactions:   txs,
+		TokenTransfers: tokens,
+		NFTTransfers:   nfts,
+	}
+}
+
+func transfer(hash, from, to string, value int64, succesful bool) Transaction {
+	return Transaction{Hash: hash, Type: "transfer", From: from, To: to, Value: decimal.NewFromInt(value), Succesful: succesful}
+}
+
+func fee(hash, from string, value int64) Transaction {
+	return Transaction{Hash: hash + "_fee", Type: "fee", From: from, To: "", Value: decimal.NewFromInt(value), Succesful: true}
+}
+
+// indexedFor marks the block as indexed for the accounts.
+func indexedFor(block IndexedBlock, accounts ...string) IndexedBlock {
+	block.Accounts = accounts
+	return block
+}
+
+func commit(t *testing.T, store Store, job string, blocks ...IndexedBlock) {
+	t.Helper()
+
+	for _, block := range blocks {
+		if err := store.CommitBlock(context.Background(), job, block); err != nil {
+			t.Fatalf("CommitBlock %d: %v", block.Block.Number, err)
+		}
+	}
+}
+
+func TestTransactionPagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		commit(t, store, "",
+			testBlock(10, []Transaction{transfer("0x01", bob, alice, 1000, true)}, nil, nil),
+			testBlock(11, []Transaction{transfer("0x02", alice, bob, 300, true), fee("0x02", alice, 21)}, nil, nil),
+			testBlock(12, []Transaction{transfer("0x03", alice, carol, 500, false), fee("0x03", alice, 21)}, nil, nil),
+			// Same timestamp, ordered by hash
+			testBlock(13, []Transaction{transfer("0x04", carol, alice, 50, true), transfer("0x05", carol, alice, 70, true)}, nil, nil),
+			testBlock(14, []Transaction{transfer("0x06", bob, carol, 10, true)}, nil, nil),
+		)
+
+		failed := false
+		from, to := uint64(11), uint64(12)
+		minValue := decimal.NewFromInt(100)
+		rangeStart, rangeEnd := genesis.Add(22*time.Second), genesis.Add(26*time.Second)
+
+		tests := []struct {
+			name   string
+			filter TransactionFilter
+			// Lists the transactions in the time range instead of alice's
+			inRange bool
+			want    []string
+		}{
+			{name: "everything", want: []string{"0x05", "0x04", "0x03_fee", "0x03", "0x02_fee", "0x02", "0x01"}},
+			{name: "received", filter: TransactionFilter{Direction: "in"}, want: []string{"0x05", "0x04", "0x01"}},
+			{name: "sent, fees included", filter: TransactionFilter{Direction: "out"}, want: []string{"0x03_fee", "0x03", "0x02_fee", "0x02"}},
+			{name: "fees", filter: TransactionFilter{Type: "fee"}, want: []string{"0x03_fee", "0x02_fee"}},
+			{name: "failed", filter: TransactionFilter{Succesful: &failed}, want: []string{"0x03"}},
+			{name: "blocks", filter: TransactionFilter{FromBlock: &from, ToBlock: &to}, want: []string{"0x03_fee", "0x03", "0x02_fee", "0x02"}},
+			{name: "min value", filter: TransactionFilter{MinValue: &minValue}, want: []string{"0x03", "0x02", "0x01"}},
+			{name: "time range", inRange: true, want: []string{"0x05", "0x04", "0x03_fee", "0x03", "0x02_fee", "0x02"}},
+			{name: "time range with filter", inRange: true, filter: TransactionFilter{Type: "transfer"}, want: []string{"0x05", "0x04", "0x03", "0x02"}},
+		}
+
+		for _, tt := range tests {
+			for _, limit := range []int{1, 2, 3, 100} {
+				t.Run(fmt.Sprintf("%s/limit %d", tt.name, limit), func(t *testing.T) {
+					filter := tt.filter
+					filter.Limit = limit
+
+					got := []string{}
+					for pages := 0; ; pages++ {
+						if pages > len(tt.want) {
+							t.Fatalf("more pages than transactions, got %v so far", got)
+						}
+
+						var page TransactionPage
+						var err error
+						if tt.inRange {
+							page, err = store.GetTransactionsInRange(ctx, rangeStart, rangeEnd, filter)
+						} else {
+							page, err = store.GetTransactionsFromAddress(ctx, alice, filter)
+						}
+						if err != nil {
+							t.Fatalf("listing transactions: %v", err)
+						}
+						if len(page.Transactions) > limit {
+							t.Fatalf("got %d transactions, limit %d", len(page.Transactions), limit)
+						}
+
+						for _, tx := range page.Transactions {
+							got = append(got, tx.Hash)
+						}
+						if page.Next == nil {
+							break
+						}
+						filter.Cursor = page.Next
+					}
+
+					if !slices.Equal(got, tt.want) {
+						t.Errorf("got %v, want %v", got, tt.want)
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestRollbackBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		token := "0x00000000000000000000000000000000000000d0"
+
+		var blocks []IndexedBlock
+		for number := uint64(10); number < 15; number++ {
+			hash := fmt.Sprintf("0x%02d", number)
+			blocks = append(blocks, indexedFor(testBlock(number,
+				[]Transaction{transfer(hash, bob, alice, int64(number), true)},
+				[]TokenTransfer{{TransactionHash: hash, LogIndex: 1, Token: token, From: bob, To: alice, Value: decimal.NewFromInt(int64(number))}},
+				[]NFTTransfer{{TransactionHash: hash, LogIndex: 2, Contract: token, Standard: "erc721", TokenID: decimal.NewFromInt(int64(number)), Amount: decimal.NewFromInt(1), From: bob, To: alice}},
+			), alice))
+		}
+		commit(t, store, "follow", blocks...)
+
+		if err := store.RollbackBlocks(ctx, "follow", 12); err != nil {
+			t.Fatalf("RollbackBlocks: %v", err)
+		}
+
+		if last, found, err := store.GetLastIndexedBlock(ctx); err != nil || !found || last != 11 {
+			t.Errorf("last indexed block = %d, %t, %v, want 11", last, found, err)
+		}
+		if checkpoint, found, err := store.GetCheckpoint(ctx, "follow"); err != nil || !found || checkpoint != 11 {
+			t.Errorf("checkpoint = %d, %t, %v, want 11", checkpoint, found, err)
+		}
+		if _, found, err := store.GetBlock(ctx, 12); err != nil || found {
+			t.Errorf("block 12 found = %t, %v, want it rolled back", found, err)
+		}
+		if ranges, err := store.GetMissingBlockRanges(ctx, alice, 10, 14); err != nil || !slices.Equal(ranges, []BlockRange{{From: 12, To: 14}}) {
+			t.Errorf("missing ranges = %+v, %v, want 12 to 14", ranges, err)
+		}
+
+		balance, err := store.GetBalance(ctx, alice)
+		if err != nil {
+			t.Fatalf("GetBalance: %v", err)
+		}
+		if !balance.Balance.Equal(decimal.NewFromInt(10+11)) || balance.Transactions != 2 {
+			t.Errorf("balance = %+v, want 21 from 2 transactions", balance)
+		}
+
+		tokens, err := store.GetTokenTransfersFromAddress(ctx, alice, "")
+		if err != nil {
+			t.Fatalf("GetTokenTransfersFromAddress: %v", err)
+		}
+		if len(tokens) != 2 {
+			t.Errorf("got %d token transfers, want 2", len(tokens))
+		}
+
+		nfts, err := store.GetNFTHoldings(ctx, alice)
+		if err != nil {
+			t.Fatalf("GetNFTHoldings: %v", err)
+		}
+		if len(nfts) != 2 {
+			t.Errorf("got %d NFTs, want 2", len(nfts))
+		}
+
+		// The replacement blocks go on top
+		commit(t, store, "follow", indexedFor(testBlock(12, []Transaction{transfer("0x12b", alice, bob, 5, true)}, nil, nil), alice))
+		if first, found, err := store.GetFirstIndexedBlock(ctx, alice); err != nil || !found || first != 10 {
+			t.Errorf("first indexed block = %d, %t, %v, want 10", first, found, err)
+		}
+		if ranges, err := store.GetMissingBlockRanges(ctx, alice, 10, 12); err != nil || len(ranges) != 0 {
+			t.Errorf("missing ranges = %+v, %v, want none", ranges, err)
+		}
+	})
+}
+
+func TestRollbackBlocksRewindsJobs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		// run claims the next job and indexes its blocks up to to, leaving it running
+		run := func(job Job, to uint64) {
+			t.Helper()
+			if claimed, found, err := store.ClaimJob(ctx, time.Hour); err != nil || !found || claimed.ID != job.ID {
+				t.Fatalf("ClaimJob = %d, %t, %v, want job %d", claimed.ID, found, err, job.ID)
+			}
+			for number := job.FromBlock; number <= to; number++ {
+				commit(t, store, JobCheckpoint(job.ID), testBlock(number, nil, nil, nil))
+			}
+			if err := store.UpdateJobProgress(ctx, job.ID, to-job.FromBlock+1); err != nil {
+				t.Fatalf("UpdateJobProgress: %v", err)
+			}
+		}
+
+		done := enqueueRunnable(t, store, 10, 14)
+		run(done, 14)
+		before := enqueueRunnable(t, store, 5, 8)
+		run(before, 8)
+		for _, job := range []Job{done, before} {
+			if err := store.CompleteJob(ctx, job.ID); err != nil {
+				t.Fatalf("CompleteJob: %v", err)
+			}
+		}
+		running := enqueueRunnable(t, store, 11, 14)
+		run(running, 13)
+		released := enqueueRunnable(t, store, 13, 20)
+		run(released, 14)
+		if err := store.ReleaseJob(ctx, released.ID); err != nil {
+			t.Fatalf("ReleaseJob: %v", err)
+		}
+
+		if err := store.RollbackBlocks(ctx, "follow", 12); err != nil {
+			t.Fatalf("RollbackBlocks: %v", err)
+		}
+
+		tests := []struct {
+			name         string
+			id           int64
+			wantStatus   string
+			wantProgress uint64
+			// Checkpoint of the job, ignored if it shouldn't have one
+			wantCheckpoint uint64
+			noCheckpoint   bool
+		}{
+			{"done job goes back to the queue", done.ID, JobPending, 2, 11, false},
+			{"job before the rolled back blocks", before.ID, JobDone, 4, 8, false},
+			{"job starting after the rolled back block starts over", released.ID, JobPending, 0, 0, true},
+			{"running job is left to its worker", running.ID, JobRunning, 3, 13, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				job, found, err := store.GetJob(ctx, tt.id)
+				if err != nil || !found {
+					t.Fatalf("GetJob: %t, %v", found, err)
+				}
+				if job.Status != tt.wantStatus || job.Progress != tt.wantProgress {
+					t.Errorf("job is %s with progress %d, want %s with %d", job.Status, job.Progress, tt.wantStatus, tt.wantProgress)
+				}
+				if job.Status == JobPending && job.FinishedAt != nil {
+					t.Errorf("pending job finished at %v", job.FinishedAt)
+				}
+
+				checkpoint, found, err := store.GetCheckpoint(ctx, JobCheckpoint(tt.id))
+				if err != nil {
+					t.Fatalf("GetCheckpoint: %v", err)
+				}
+				if tt.noCheckpoint && found {
+					t.Errorf("checkpoint = %d, want none", checkpoint)
+				}
+				if !tt.noCheckpoint && (!found || checkpoint != tt.wantCheckpoint) {
+					t.Errorf("checkpoint = %d, %t, want %d", checkpoint, found, tt.wantCheckpoint)
+				}
+			})
+		}
+
+		// The blocks rolled back under the running job are indexed again by a new job
+		reindex, found, err := store.GetJob(ctx, released.ID+1)
+		if err != nil || !found {
+			t.Fatalf("GetJob of the reindex: %t, %v", found, err)
+		}
+		if reindex.Kind != JobKindReindex || reindex.Status != JobPending || reindex.FromBlock != 12 || reindex.ToBlock == nil || *reindex.ToBlock != 14 {
+			t.Errorf("reindex = %+v, want a pending reindex of blocks 12 to 14", reindex)
+		}
+
+		if claimed, found, err := store.ClaimJob(ctx, time.Hour); err != nil || !found || claimed.ID != done.ID {
+			t.Errorf("ClaimJob = %d, %t, %v, want the rewound job %d", claimed.ID, found, err, done.ID)
+		}
+	})
+}
+
+func TestMissingBlockRanges(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		commit(t, store, "",
+			indexedFor(testBlock(5, nil, nil, nil), alice),
+			indexedFor(testBlock(6, nil, nil, nil), alice),
+			// Indexed, but not for alice, e.g. by a backfill of bob
+			indexedFor(testBlock(7, nil, nil, nil), bob),
+			indexedFor(testBlock(8, nil, nil, nil), alice, bob),
+			indexedFor(testBlock(9, nil, nil, nil), alice),
+			indexedFor(testBlock(11, nil, nil, nil), alice),
+		)
+
+		tests := []struct {
+			address   string
+			from, to  uint64
+			wantFirst uint64
+			wantFound bool
+			want      []BlockRange
+		}{
+			{alice, 5, 11, 5, true, []BlockRange{{From: 7, To: 7}, {From: 10, To: 10}}},
+			{alice, 3, 13, 5, true, []BlockRange{{From: 3, To: 4}, {From: 7, To: 7}, {From: 10, To: 10}, {From: 12, To: 13}}},
+			{alice, 8, 9, 5, true, []BlockRange{}},
+			{bob, 7, 11, 7, true, []BlockRange{{From: 9, To: 11}}},
+			{carol, 0, 2, 0, false, []BlockRange{{From: 0, To: 2}}},
+		}
+		for _, tt := range tests {
+			first, found, err := store.GetFirstIndexedBlock(ctx, tt.address)
+			if err != nil || found != tt.wantFound || first != tt.wantFirst {
+				t.Errorf("first indexed block of %s = %d, %t, %v, want %d, %t", tt.address, first, found, err, tt.wantFirst, tt.wantFound)
+			}
+
+			ranges, err := store.GetMissingBlockRanges(ctx, tt.address, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("GetMissingBlockRanges: %v", err)
+			}
+			if !slices.Equal(ranges, tt.want) {
+				t.Errorf("missing ranges of %s between %d and %d = %+v, want %+v", tt.address, tt.from, tt.to, ranges, tt.want)
+			}
+		}
+	})
+}
+
+func TestJobQueue(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		// Waits for the follower
+		backfill, err := store.EnqueueJob(ctx, Job{Kind: JobKindBackfill, Address: &alice, FromBlock: 5})
+		if err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+		first := enqueueRunnable(t, store, 10, 20)
+		second := enqueueRunnable(t, store, 30, 40)
+
+		claim := func() (Job, bool) {
+			t.Helper()
+			job, found, err := store.ClaimJob(ctx, time.Hour)
+			if err != nil {
+				t.Fatalf("ClaimJob: %v", err)
+			}
+			return job, found
+		}
+
+		// Oldest first, never the same twice, and not the backfill without its last block
+		if job, found := claim(); !found || job.ID != first.ID || job.Status != JobRunning || job.StartedAt == nil {
+			t.Fatalf("claimed %+v, want job %d running", job, first.ID)
+		}
+		if job, found := claim(); !found || job.ID != second.ID {
+			t.Fatalf("claimed %+v, want job %d", job, second.ID)
+		}
+		if job, found := claim(); found {
+			t.Fatalf("claimed %+v, nothing is runnable", job)
+		}
+
+		scheduled, err := store.ScheduleBackfills(ctx, 99)
+		if err != nil {
+			t.Fatalf("ScheduleBackfills: %v", err)
+		}
+		if len(scheduled) != 1 || scheduled[0].ID != backfill.ID || scheduled[0].ToBlock == nil || *scheduled[0].ToBlock != 99 {
+			t.Fatalf("scheduled %+v, want job %d up to block 99", scheduled, backfill.ID)
+		}
+		if scheduled, err := store.ScheduleBackfills(ctx, 150); err != nil || len(scheduled) != 0 {
+			t.Fatalf("scheduled %+v, %v again", scheduled, err)
+		}
+		if job, found := claim(); !found || job.ID != backfill.ID {
+			t.Fatalf("claimed %+v, want the scheduled backfill %d", job, backfill.ID)
+		}
+
+		if err := store.UpdateJobProgress(ctx, first.ID, 4); err != nil {
+			t.Fatalf("UpdateJobProgress: %v", err)
+		}
+		if err := store.CompleteJob(ctx, first.ID); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+
+		// Released on shutdown, not a failure
+		if err := store.ReleaseJob(ctx, second.ID); err != nil {
+			t.Fatalf("ReleaseJob: %v", err)
+		}
+		if job, found := claim(); !found || job.ID != second.ID || job.Attempts != 0 {
+			t.Fatalf("claimed %+v, want job %d without failed attempts", job, second.ID)
+		}
+		for attempt := 1; attempt <= 2; attempt++ {
+			status, err := store.FailJob(ctx, second.ID, "boom", 2, 0)
+			if err != nil {
+				t.Fatalf("FailJob: %v", err)
+			}
+			want := JobPending
+			if attempt == 2 {
+				want = JobFailed
+			}
+			if status != want {
+				t.Fatalf("status after attempt %d = %s, want %s", attempt, status, want)
+			}
+			if attempt == 1 {
+				if job, found := claim(); !found || job.ID != second.ID {
+					t.Fatalf("claimed %+v, want the retry of job %d", job, second.ID)
+				}
+			}
+		}
+
+		done, found, err := store.GetJob(ctx, first.ID)
+		if err != nil || !found {
+			t.Fatalf("GetJob = %t, %v", found, err)
+		}
+		if done.Status != JobDone || done.Progress != 4 || done.FinishedAt == nil || done.Error != nil {
+			t.Errorf("job = %+v, want done with progress 4", done)
+		}
+		failedJob, _, err := store.GetJob(ctx, second.ID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if failedJob.Status != JobFailed || failedJob.Attempts != 2 || failedJob.Error == nil || *failedJob.Error != "boom" || failedJob.FinishedAt == nil {
+			t.Errorf("job = %+v, want failed after 2 attempts", failedJob)
+		}
+		if _, found, err := store.GetJob(ctx, 12345); err != nil || found {
+			t.Errorf("GetJob of a missing job = %t, %v", found, err)
+		}
+
+		listTests := []struct {
+			name   string
+			filter JobFilter
+			want   []int64
+		}{
+			{"all, newest first", JobFilter{}, []int64{second.ID, first.ID, backfill.ID}},
+			{"by status", JobFilter{Status: JobRunning}, []int64{backfill.ID}},
+			{"limited", JobFilter{Limit: 2}, []int64{second.ID, first.ID}},
+		}
+		for _, tt := range listTests {
+			t.Run(tt.name, func(t *testing.T) {
+				jobs, err := store.GetJobs(ctx, tt.filter)
+				if err != nil {
+					t.Fatalf("GetJobs: %v", err)
+				}
+				ids := []int64{}
+				for _, job := range jobs {
+					ids = append(ids, job.ID)
+				}
+				if !slices.Equal(ids, tt.want) {
+					t.Errorf("jobs = %v, want %v", ids, tt.want)
+				}
+			})
+		}
+	})
+}
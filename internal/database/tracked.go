@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AddTrackedAddress registers an address to index, returns false if it was already tracked.
+// With a start block, the backfill of its history is queued in the same transaction.
+func (db *DBClient) AddTrackedAddress(ctx context.Context, address string, startBlock *uint64) (TrackedAddress, bool, error) {
+	dbTx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return TrackedAddress{}, false, err
+	}
+	defer dbTx.Rollback(ctx)
+
+	tracked := TrackedAddress{Address: address, StartBlock: startBlock}
+
+	err = dbTx.QueryRow(ctx, `
+		INSERT INTO tracked_addresses (address, start_block)
+		VALUES ($1, $2)
+		ON CONFLICT (address) DO NOTHING
+		RETURNING created_at;
+	`, address, startBlock).Scan(&tracked.CreatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TrackedAddress{}, false, nil
+	}
+	if err != nil {
+		return TrackedAddress{}, false, err
+	}
+
+	if startBlock != nil {
+		var jobID int64
+		err := dbTx.QueryRow(ctx, `
+			INSERT INTO jobs (kind, address, from_block)
+			VALUES ($1, $2, $3)
+			RETURNING id;
+		`, JobKindBackfill, address, *startBlock).Scan(&jobID)
+		if err != nil {
+			return TrackedAddress{}, false, err
+		}
+
+		if _, err := dbTx.Exec(ctx, `
+			UPDATE tracked_addresses
+			SET backfill_job = $2
+			WHERE address = $1;
+		`, address, jobID); err != nil {
+			return TrackedAddress{}, false, err
+		}
+		tracked.BackfillJob = &jobID
+	}
+
+	return tracked, true, dbTx.Commit(ctx)
+}
+
+// GetTrackedAddresses returns every address registered through the API, oldest first.
+func (db *DBClient) GetTrackedAddresses(ctx context.Context) ([]TrackedAddress, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT address, start_block, backfill_job, created_at
+		FROM tracked_addresses
+		ORDER BY created_at, address;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracked := []TrackedAddress{}
+	for rows.Next() {
+		var t TrackedAddress
+		if err := rows.Scan(&t.Address, &t.StartBlock, &t.BackfillJob, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tracked = append(tracked, t)
+	}
+
+	return tracked, rows.Err()
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// TransactionFilter narrows down the transactions listed, every field is optional.
+type TransactionFilter struct {
+	// "in" or "out" relative to the address, fees only count as "out"
+	Direction string
+	// "transfer", "call" or "fee"
+	Type      string
+	Succesful *bool
+	// Inclusive block range
+	FromBlock *uint64
+	ToBlock   *uint64
+	MinValue  *decimal.Decimal
+	// Defaults to DefaultPageSize, capped at MaxPageSize
+	Limit int
+	// Where the previous page ended
+	Cursor *TransactionCursor
+}
+
+// TransactionCursor is the position of the last transaction of a page. Transactions are sorted
+// by timestamp and hash, so the next page starts right after it.
+type TransactionCursor struct {
+	Timestamp time.Time `json:"t"`
+	Hash      string    `json:"h"`
+}
+
+// Encode returns the opaque string handed out to API clients.
+func (tc TransactionCursor) Encode() string {
+	b, _ := json.Marshal(tc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeTransactionCursor(value string) (*TransactionCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	var tc TransactionCursor
+	if err := json.Unmarshal(b, &tc); err != nil || tc.Hash == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &tc, nil
+}
+
+// TransactionPage is a page of transactions, Next is nil on the last page.
+type TransactionPage struct {
+	Transactions []Transaction
+	Next         *TransactionCursor
+}
+
+func (db *DBClient) GetTransactionsFromAddress(ctx context.Context, address string, filter TransactionFilter) (TransactionPage, error) {
+	q := newQuery()
+
+	switch filter.Direction {
+	case "in":
+		q.where("to_address = %s AND type <> 'fee'", address)
+	case "out":
+		q.where("from_address = %s", address)
+	default:
+		q.where("(from_address = %[1]s OR to_address = %[1]s)", address)
+	}
+
+	return db.listTransactions(ctx, q, filter)
+}
+
+func (db *DBClient) GetTransactionsInRange(ctx context.Context, start, end time.Time, filter TransactionFilter) (TransactionPage, error) {
+	q := newQuery()
+	q.where("timestamp >= %s", start)
+	q.where("timestamp <= %s", end)
+
+	return db.listTransactions(ctx, q, filter)
+}
+
+func (db *DBClient) listTransactions(ctx context.Context, q *query, filter TransactionFilter) (TransactionPage, error) {
+	if filter.Type != "" {
+		q.where("type = %s", filter.Type)
+	}
+	if filter.Succesful != nil {
+		q.where("succesful = %s", *filter.Succesful)
+	}
+	if filter.FromBlock != nil {
+		q.where("block_number >= %s", *filter.FromBlock)
+	}
+	if filter.ToBlock != nil {
+		q.where("block_number <= %s", *filter.ToBlock)
+	}
+	if filter.MinValue != nil {
+		q.where("value >= %s", *filter.MinValue)
+	}
+	if filter.Cursor != nil {
+		q.where("(timestamp, hash) < (%s, %s)", filter.Cursor.Timestamp, filter.Cursor.Hash)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	// One extra row tells us if there is a next page
+	rows, err := db.Pool.Query(ctx, fmt.Sprintf(`
+		SELECT hash, type, value, from_address, to_address, block_index, succesful, timestamp AT TIME ZONE 'UTC'
+		FROM transactions
+		WHERE %s
+		ORDER BY timestamp DESC, hash DESC
+		LIMIT %d;
+	`, q.conditions(), limit+1), q.args...)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	defer rows.Close()
+
+	txs := []Transaction{}
+	for rows.Next() {
+		var tx Transaction
+		if err := rows.Scan(
+			&tx.Hash, &tx.Type, &tx.Value, &tx.From, &tx.To,
+			&tx.BlockIndex, &tx.Succesful, &tx.Timestamp,
+		); err != nil {
+			return TransactionPage{}, err
+		}
+
+		block, _ := data.NewHexFromString(tx.BlockIndex)
+		tx.BlockIndex = block.Int.String()
+
+		txs = append(txs, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return TransactionPage{}, err
+	}
+
+	page := TransactionPage{Transactions: txs}
+	if len(txs) > limit {
+		page.Transactions = txs[:limit]
+		last := page.Transactions[limit-1]
+		page.Next = &TransactionCursor{Timestamp: last.Timestamp, Hash: last.Hash}
+	}
+
+	return page, nil
+}
+
+// query collects WHERE conditions and numbers their placeholders.
+type query struct {
+	conds []string
+	args  []any
+}
+
+func newQuery() *query {
+	return &query{}
+}
+
+// where adds a condition, every %s in it is replaced by the placeholder of the matching arg.
+// Use %[1]s to refer to the same arg more than once.
+func (q *query) where(cond string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, arg := range args {
+		q.args = append(q.args, arg)
+		placeholders[i] = fmt.Sprintf("$%d", len(q.args))
+	}
+	q.conds = append(q.conds, fmt.Sprintf(cond, placeholders...))
+}
+
+func (q *query) conditions() string {
+	if len(q.conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(q.conds, " AND ")
+}
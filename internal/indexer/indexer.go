@@ -0,0 +1,344 @@
+// Package indexer turns Base blocks into the rows we store for the tracked accounts.
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+)
+
+// Node is the part of rpc.Client the indexer uses.
+type Node interface {
+	GetBlockByNumber(ctx context.Context, block data.Hex, full bool) (*rpc.BlockDTO, error)
+	GetBlockReceipts(ctx context.Context, block data.Hex) (*rpc.BlockReceiptsDTO, error)
+	GetTransactionCallTrace(ctx context.Context, transactionHash string) (*rpc.GetTransactionCallTraceDTO, error)
+	GetTransactionCallTraces(ctx context.Context, transactionHashes []string) (map[string]*rpc.GetTransactionCallTraceDTO, error)
+	GetLogsBatch(ctx context.Context, filters []rpc.LogFilter) ([]*rpc.LogsDTO, error)
+}
+
+// Indexer processes blocks from the node and stores the result.
+type Indexer struct {
+	node  Node
+	store database.Store
+}
+
+func New(node Node, store database.Store) *Indexer {
+	return &Indexer{
+		node:  node,
+		store: store,
+	}
+}
+
+// IndexBlock processes the block and stores it, see Commit.
+func (ix *Indexer) IndexBlock(ctx context.Context, job string, number uint64, accounts map[string]bool) (*database.IndexedBlock, error) {
+	indexed, err := ix.ProcessBlock(ctx, number, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ix.Commit(ctx, job, indexed); err != nil {
+		return nil, err
+	}
+
+	return indexed, nil
+}
+
+// Commit stores a processed block and moves the checkpoint of the job to it, if job isn't empty.
+func (ix *Indexer) Commit(ctx context.Context, job string, indexed *database.IndexedBlock) error {
+	block := indexed.Block
+
+	log.Printf("Processed block %d with %d transactions, %d token transfers and %d NFT transfers", block.Number, len(indexed.Transactions), len(indexed.TokenTransfers), len(indexed.NFTTransfers))
+
+	if err := ix.store.CommitBlock(ctx, job, *indexed); err != nil {
+		log.Printf("Error upserting transactions for block %d: %v", block.Number, err)
+		return err
+	}
+
+	return nil
+}
+
+// ProcessBlock fetches the block and extracts the transactions and transfers involving the
+// accounts, without storing anything.
+func (ix *Indexer) ProcessBlock(ctx context.Context, number uint64, accounts map[string]bool) (*database.IndexedBlock, error) {
+	blockIdx := *data.NewHexFromUint64(number)
+
+	blockDTO, err := ix.node.GetBlockByNumber(ctx, blockIdx, true)
+	if err != nil {
+		log.Printf("Error getting block %s: %v", blockIdx.String(), err)
+		return nil, err
+	}
+
+	block, err := newBlock(blockDTO.Result.BlockHeader)
+	if err != nil {
+		log.Printf("Error parsing block %s: %v", blockIdx.String(), err)
+		return nil, err
+	}
+
+	blockTimestamp := block.Timestamp
+
+	var receiptsDTO *rpc.BlockReceiptsDTO
+	transactions := []database.Transaction{}
+
+	log.Printf("Processing block %s at index %d, at timestamp %s", blockDTO.Result.Number, blockIdx.Uint64(), blockDTO.Result.Timestamp)
+
+	// Trace all the relevant contract calls of the block in a single round trip
+	traceHashes := []string{}
+	for _, txDto := range blockDTO.Result.Transactions {
+		if (accounts[txDto.From] || accounts[txDto.To]) && txDto.Input != "0x" {
+			traceHashes = append(traceHashes, txDto.Hash)
+		}
+	}
+
+	traces := map[string]*rpc.GetTransactionCallTraceDTO{}
+	if len(traceHashes) > 0 {
+		traces, err = ix.node.GetTransactionCallTraces(ctx, traceHashes)
+		if err != nil {
+			// Not fatal, processContractCall traces them one by one instead
+			log.Printf("Error getting call traces for block %s: %v", blockIdx.String(), err)
+			traces = map[string]*rpc.GetTransactionCallTraceDTO{}
+		}
+	}
+
+	// Go through the transactions
+	for _, txDto := range blockDTO.Result.Transactions {
+		// Irrelevant transaction
+		if !accounts[txDto.From] && !accounts[txDto.To] {
+			continue
+		}
+
+		var receiptDTO *rpc.Receipt
+		// Get the receipts if we haven't already
+		if receiptsDTO == nil {
+			receiptsDTO, err = ix.node.GetBlockReceipts(ctx, blockIdx)
+
+			// Without receipts we can't tell if the transactions went through, so the block can't be stored
+			if err != nil {
+				log.Printf("Error getting receipts for block %s: %v", blockIdx.String(), err)
+				return nil, err
+			}
+		}
+
+		// Get the receipt for the matching TX
+		for _, r := range receiptsDTO.Result {
+			if r.TransactionHash == txDto.Hash {
+				receiptDTO = &r
+				break
+			}
+		}
+
+		// Skipping it would store the block without the transaction and move past it
+		if receiptDTO == nil {
+			return nil, fmt.Errorf("no receipt found for transaction %s in block %s", txDto.Hash, blockIdx.String())
+		}
+
+		log.Printf("Processing transaction %s from %s to %s with value %s at block index %s", txDto.Hash, txDto.From, txDto.To, txDto.Value, blockIdx.String())
+
+		var trx database.Transaction
+
+		trx.Timestamp = blockTimestamp
+		trx.BlockIndex = blockDTO.Result.Number
+		trx.BlockNumber = block.Number
+
+		trx.Hash = txDto.Hash
+		trx.To = txDto.To
+		trx.From = txDto.From
+
+		trx.Type = "transfer"
+		if txDto.Input != "0x" {
+			trx.Type = "call"
+		}
+
+		if receiptDTO.Status == "0x1" {
+			trx.Succesful = true
+		}
+
+		amount, err := data.NewHexFromString(txDto.Value)
+		if err != nil {
+			log.Printf("Error parsing transaction value %s: %v", txDto.Value, err)
+			continue
+		}
+
+		trx.Value = decimal.NewFromBigInt(amount.Int, 0)
+
+		log.Printf("Transaction details: %+v", trx)
+
+		transactions = append(transactions, trx)
+
+		// Recursively add calls
+		if trx.Type == "call" {
+			err := ix.processContractCall(ctx, trx, traces[trx.Hash], accounts, &transactions)
+			// Storing the block without its internal calls would get the balances wrong
+			if err != nil {
+				log.Printf("Error processing contract call for transaction %s: %v", trx.Hash, err)
+				return nil, err
+			}
+		}
+
+		// Fee
+		var fee database.Transaction
+		fee.Hash = trx.Hash + "_fee"
+		fee.Type = "fee"
+		fee.From = trx.From
+		fee.To = trx.From // This is not really used, but it is a valid address
+		fee.BlockIndex = trx.BlockIndex
+		fee.BlockNumber = trx.BlockNumber
+		fee.Timestamp = trx.Timestamp
+		fee.Succesful = true // Fees are always successful
+
+		l1Fee := decimal.Zero
+		if receiptDTO.L1Fee != nil {
+			l1FeeHex, err := data.NewHexFromString(*receiptDTO.L1Fee)
+			if err != nil {
+				log.Printf("Error parsing L1 fee %s: %v", *receiptDTO.L1Fee, err)
+				continue
+			}
+			l1Fee = decimal.NewFromBigInt(l1FeeHex.Int, 0)
+		}
+		effectiveGasPriceHex, err := data.NewHexFromString(receiptDTO.EffectiveGasPrice)
+		if err != nil {
+			log.Printf("Error parsing effective gas price %s: %v", receiptDTO.EffectiveGasPrice, err)
+			continue
+		}
+		effectiveGasPrice := decimal.NewFromBigInt(effectiveGasPriceHex.Int, 0)
+
+		gasUsedHex, err := data.NewHexFromString(receiptDTO.GasUsed)
+		if err != nil {
+			log.Printf("Error parsing gas used %s: %v", receiptDTO.GasUsed, err)
+			continue
+		}
+		gasUsed := decimal.NewFromBigInt(gasUsedHex.Int, 0)
+
+		fee.Value = effectiveGasPrice.Mul(gasUsed).Add(l1Fee)
+
+		log.Printf("Fee details: %+v", fee)
+
+		transactions = append(transactions, fee)
+	}
+
+	tokenTransfers, nftTransfers, err := ix.processTokenTransfers(ctx, block, accounts)
+	if err != nil {
+		log.Printf("Error processing token transfers for block %s: %v", blockIdx.String(), err)
+		return nil, err
+	}
+
+	return &database.IndexedBlock{
+		Block:          *block,
+		Transactions:   transactions,
+		TokenTransfers: tokenTransfers,
+		NFTTransfers:   nftTransfers,
+		Accounts:       slices.Sorted(maps.Keys(accounts)),
+	}, nil
+}
+
+func newBlock(header rpc.BlockHeader) (*database.Block, error) {
+	number, err := data.NewHexFromString(header.Number)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block number %s: %w", header.Number, err)
+	}
+
+	timestamp, err := data.NewHexFromString(header.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block timestamp %s: %w", header.Timestamp, err)
+	}
+
+	return &database.Block{
+		Number:     number.Uint64(),
+		Hash:       header.Hash,
+		ParentHash: header.ParentHash,
+		Timestamp:  time.Unix(timestamp.Int64(), 0),
+	}, nil
+}
+
+// processContractCall adds the internal calls of the transaction, using the prefetched trace if there is one.
+func (ix *Indexer) processContractCall(ctx context.Context, origin database.Transaction, calls *rpc.GetTransactionCallTraceDTO, accounts map[string]bool, transactions *[]database.Transaction) error {
+	if calls == nil {
+		var err error
+		calls, err = ix.node.GetTransactionCallTrace(ctx, origin.Hash)
+
+		if err != nil {
+			log.Printf("Error getting call trace for transaction %s: %v", origin.Hash, err)
+			return err
+		}
+	}
+
+	return recurseCallStack(origin, calls.Result.Calls, accounts, transactions, new(int))
+}
+
+// recurseCallStack adds the calls moving value from or to the accounts. They are numbered by
+// their position in the whole trace, so indexing the block again for other accounts gives the
+// same call the same hash.
+func recurseCallStack(origin database.Transaction, callStack []rpc.CallTrace, accounts map[string]bool, transactions *[]database.Transaction, count *int) error {
+	for _, call := range callStack {
+		*count++
+		position := *count
+
+		// Skip if no value was transferred
+		if call.Value == "0x0" || call.Value == "0x" || call.Value == "" {
+			// Still recurse to deeper calls even if this call itself had no value
+			if len(call.Calls) > 0 {
+				err := recurseCallStack(origin, call.Calls, accounts, transactions, count)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Parse value
+		valHex, err := data.NewHexFromString(call.Value)
+		if err != nil {
+			log.Printf("Error parsing internal call value %s: %v", call.Value, err)
+			continue
+		}
+
+		// Only include if from or to is in accounts map
+		if !accounts[call.From] && !accounts[call.To] {
+			// Still recurse
+			if len(call.Calls) > 0 {
+				err := recurseCallStack(origin, call.Calls, accounts, transactions, count)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		trx := database.Transaction{
+			From:        call.From,
+			To:          call.To,
+			Hash:        origin.Hash + "_internal_" + fmt.Sprintf("%d", position),
+			Value:       decimal.NewFromBigInt(valHex.Int, 0),
+			BlockIndex:  origin.BlockIndex,
+			BlockNumber: origin.BlockNumber,
+			Timestamp:   origin.Timestamp,
+			Succesful:   true,
+		}
+
+		trx.Type = "transfer"
+		if call.Input != "0x" {
+			trx.Type = "call"
+		}
+
+		log.Printf("Processing internal call %d: %+v", position, trx)
+
+		*transactions = append(*transactions, trx)
+
+		// Recurse
+		if len(call.Calls) > 0 {
+			err := recurseCallStack(origin, call.Calls, accounts, transactions, count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
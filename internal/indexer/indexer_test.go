@@ -0,0 +1,224 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/indexer/indexertest"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+var (
+	account  = rpctest.Address(1)
+	other    = rpctest.Address(2)
+	contract = rpctest.Address(3)
+	token    = rpctest.Address(4)
+	accounts = map[string]bool{account: true}
+	start    = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// setup returns an indexer reading from a fake node serving the chain, and storing in memory.
+func setup(t *testing.T, chain *rpctest.Chain) (*Indexer, *rpctest.Node, *database.MemoryStore) {
+	t.Helper()
+
+	client, node, store := indexertest.Setup(t, chain)
+
+	return New(client, store), node, store
+}
+
+func transferLog(from, to string, value int64) rpc.Log {
+	return rpc.Log{
+		Address: token,
+		Topics:  []string{data.TransferTopic, data.AddressToTopic(from), data.AddressToTopic(to)},
+		Data:    fmt.Sprintf("0x%064x", value),
+	}
+}
+
+func TestProcessBlock(t *testing.T) {
+	chain := rpctest.NewChain(100, start)
+	chain.Genesis[account] = rpctest.Wei(1_000_000_000_000_000)
+
+	chain.Next(
+		// Received
+		&rpctest.Tx{From: other, To: account, Value: rpctest.Wei(1000)},
+		// Sent, with an L1 fee
+		&rpctest.Tx{From: account, To: other, Value: rpctest.Wei(300), L1Fee: rpctest.Wei(50)},
+		// Failed, only the fee is paid
+		&rpctest.Tx{From: account, To: other, Value: rpctest.Wei(500), Failed: true},
+		// Contract call paying the account back through internal calls, one of them nested
+		&rpctest.Tx{From: account, To: contract, Input: "0xabcdef", Calls: []rpc.CallTrace{
+			{From: contract, To: account, Value: "0x64", Input: "0x"},
+			{From: contract, To: other, Value: "0x10", Input: "0x", Calls: []rpc.CallTrace{
+				{From: other, To: account, Value: "0x5", Input: "0x"},
+			}},
+		}},
+		// Unrelated transaction moving tokens to the account
+		&rpctest.Tx{From: other, To: token, Input: "0xa9059cbb", Logs: []rpc.Log{transferLog(other, account, 42)}},
+		// Unrelated in every way
+		&rpctest.Tx{From: other, To: contract, Value: rpctest.Wei(7)},
+	)
+
+	ix, _, store := setup(t, chain)
+	ctx := context.Background()
+
+	indexed, err := ix.ProcessBlock(ctx, 100, accounts)
+	if err != nil {
+		t.Fatalf("ProcessBlock: %v", err)
+	}
+
+	block := chain.Block(100)
+	if indexed.Block.Hash != block.Hash || indexed.Block.ParentHash != block.ParentHash {
+		t.Errorf("block = %+v, want hash %s and parent %s", indexed.Block, block.Hash, block.ParentHash)
+	}
+	if !indexed.Block.Timestamp.Equal(block.Timestamp) {
+		t.Errorf("timestamp = %s, want %s", indexed.Block.Timestamp, block.Timestamp)
+	}
+
+	txs := block.Transactions
+	gasFee := decimal.NewFromInt(21_000 * 1_000_000_000)
+	want := []struct {
+		hash, typ string
+		value     decimal.Decimal
+		succesful bool
+	}{
+		{txs[0].Hash, "transfer", decimal.NewFromInt(1000), true},
+		// Paid by the sender, so it doesn't count for the account
+		{txs[0].Hash + "_fee", "fee", gasFee, true},
+		{txs[1].Hash, "transfer", decimal.NewFromInt(300), true},
+		{txs[1].Hash + "_fee", "fee", gasFee.Add(decimal.NewFromInt(50)), true},
+		{txs[2].Hash, "transfer", decimal.NewFromInt(500), false},
+		{txs[2].Hash + "_fee", "fee", gasFee, true},
+		{txs[3].Hash, "call", decimal.Zero, true},
+		{txs[3].Hash + "_internal_1", "transfer", decimal.NewFromInt(100), true},
+		// The call to other counts for the position, even if it isn't stored
+		{txs[3].Hash + "_internal_3", "transfer", decimal.NewFromInt(5), true},
+		{txs[3].Hash + "_fee", "fee", gasFee, true},
+	}
+
+	if len(indexed.Transactions) != len(want) {
+		t.Fatalf("got %d transactions, want %d: %+v", len(indexed.Transactions), len(want), indexed.Transactions)
+	}
+	for i, w := range want {
+		got := indexed.Transactions[i]
+		if got.Hash != w.hash || got.Type != w.typ || !got.Value.Equal(w.value) || got.Succesful != w.succesful {
+			t.Errorf("transaction %d = %s %s %s %t, want %s %s %s %t", i, got.Hash, got.Type, got.Value, got.Succesful, w.hash, w.typ, w.value, w.succesful)
+		}
+		if got.BlockNumber != 100 || got.BlockIndex != "0x64" {
+			t.Errorf("transaction %d in block %d (%s), want 100 (0x64)", i, got.BlockNumber, got.BlockIndex)
+		}
+	}
+
+	if len(indexed.TokenTransfers) != 1 {
+		t.Fatalf("got %d token transfers, want 1", len(indexed.TokenTransfers))
+	}
+	transfer := indexed.TokenTransfers[0]
+	if transfer.Token != token || transfer.From != other || transfer.To != account || !transfer.Value.Equal(decimal.NewFromInt(42)) {
+		t.Errorf("token transfer = %+v", transfer)
+	}
+
+	if err := ix.Commit(ctx, "", indexed); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	indexertest.AssertBalance(t, chain, store, account)
+}
+
+func TestProcessBlockWithoutReceipts(t *testing.T) {
+	tests := []struct {
+		name      string
+		breakNode func(node *rpctest.Node)
+	}{
+		// More than the retries of the client
+		{"failing node", func(node *rpctest.Node) { node.Fail("eth_getBlockReceipts", 10) }},
+		{"lagging node answering null", func(node *rpctest.Node) { node.Null("eth_getBlockReceipts", 10) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := rpctest.NewChain(100, start)
+			chain.Next(&rpctest.Tx{From: other, To: account, Value: rpctest.Wei(1000)})
+
+			ix, node, _ := setup(t, chain)
+			tt.breakNode(node)
+
+			if _, err := ix.ProcessBlock(context.Background(), 100, accounts); err == nil {
+				t.Fatal("ProcessBlock succeeded without receipts, it would store transactions of unknown status")
+			}
+		})
+	}
+}
+
+func TestProcessBlockRetriesTransientErrors(t *testing.T) {
+	chain := rpctest.NewChain(100, start)
+	chain.Next(&rpctest.Tx{From: other, To: account, Value: rpctest.Wei(1000)})
+
+	ix, node, _ := setup(t, chain)
+	node.Fail("eth_getBlockByNumber", 1)
+	node.Fail("eth_getBlockReceipts", 2)
+
+	indexed, err := ix.ProcessBlock(context.Background(), 100, accounts)
+	if err != nil {
+		t.Fatalf("ProcessBlock: %v", err)
+	}
+	// The transfer and its fee
+	if len(indexed.Transactions) != 2 {
+		t.Errorf("got %d transactions, want 2", len(indexed.Transactions))
+	}
+}
+
+func TestProcessBlockAgainForFewerAccounts(t *testing.T) {
+	chain := rpctest.NewChain(100, start)
+	chain.Genesis[account] = rpctest.Wei(1_000_000_000_000_000)
+	chain.Next(&rpctest.Tx{From: account, To: contract, Input: "0xabcdef", Calls: []rpc.CallTrace{
+		{From: contract, To: other, Value: "0x10", Input: "0x"},
+		{From: contract, To: account, Value: "0x20", Input: "0x"},
+	}})
+
+	ix, _, store := setup(t, chain)
+	ctx := context.Background()
+
+	// Indexed for both, then again for one of them only, like a backfill of a single address
+	for _, accounts := range []map[string]bool{{account: true, other: true}, {account: true}} {
+		indexed, err := ix.ProcessBlock(ctx, 100, accounts)
+		if err != nil {
+			t.Fatalf("ProcessBlock: %v", err)
+		}
+		if err := ix.Commit(ctx, "", indexed); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	indexertest.AssertBalance(t, chain, store, account)
+	indexertest.AssertBalance(t, chain, store, other)
+}
+
+func TestProcessBlockSkipsMalformedTransferBatch(t *testing.T) {
+	// A TransferBatch whose ids array claims 2^64-1 items
+	malformed := rpc.Log{
+		Address: contract,
+		Topics:  []string{data.TransferBatchTopic, data.AddressToTopic(other), data.AddressToTopic(other), data.AddressToTopic(account)},
+		Data:    fmt.Sprintf("0x%064x%064x%064x%064x", 0x40, 0x60, uint64(1<<64-1), 0),
+	}
+
+	chain := rpctest.NewChain(100, start)
+	chain.Next(&rpctest.Tx{From: other, To: contract, Input: "0xabcdef", Logs: []rpc.Log{malformed, transferLog(other, account, 42)}})
+
+	ix, _, _ := setup(t, chain)
+
+	indexed, err := ix.ProcessBlock(context.Background(), 100, accounts)
+	if err != nil {
+		t.Fatalf("ProcessBlock: %v", err)
+	}
+	if len(indexed.NFTTransfers) != 0 {
+		t.Errorf("got %d NFT transfers, want the malformed one skipped", len(indexed.NFTTransfers))
+	}
+	if len(indexed.TokenTransfers) != 1 {
+		t.Errorf("got %d token transfers, want 1", len(indexed.TokenTransfers))
+	}
+}
@@ -0,0 +1,46 @@
+// Package indexertest has the helpers shared by the tests of the indexer and its commands.
+package indexertest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+// Setup starts a fake node serving the chain, and returns a client of it and an empty
+// in-memory store.
+func Setup(t testing.TB, chain *rpctest.Chain) (*rpc.Client, *rpctest.Node, *database.MemoryStore) {
+	t.Helper()
+
+	node := rpctest.NewNode(t, chain)
+
+	client, err := rpc.NewClient(node.Config())
+	if err != nil {
+		t.Fatalf("creating rpc client: %v", err)
+	}
+
+	return client, node, database.NewMemoryStore()
+}
+
+// AssertBalance checks that the indexed balance of the account is what the node reports,
+// minus what the account had before the first block.
+func AssertBalance(t testing.TB, chain *rpctest.Chain, store database.Store, account string) {
+	t.Helper()
+
+	first := chain.Blocks[0].Number
+	want := new(big.Int).Sub(chain.Balance(account, chain.Head()), chain.Balance(account, first))
+
+	got, err := store.GetBalance(context.Background(), account)
+	if err != nil {
+		t.Fatalf("getting balance: %v", err)
+	}
+	if !got.Balance.Equal(decimal.NewFromBigInt(want, 0)) {
+		t.Errorf("balance = %s, node says %s", got.Balance, want)
+	}
+}
@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"context"
+	"iter"
+
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+)
+
+// FetchedBlock is the result of processing a block, Indexed is nil if Err isn't.
+type FetchedBlock struct {
+	Number  uint64
+	Indexed *database.IndexedBlock
+	Err     error
+}
+
+// FetchBlocks runs ProcessBlock over the blocks with up to concurrency blocks in flight,
+// and yields the results in the same order as the blocks. Breaking out of the loop stops
+// fetching new blocks.
+func (ix *Indexer) FetchBlocks(ctx context.Context, blocks iter.Seq[uint64], concurrency int, accounts map[string]bool) iter.Seq[FetchedBlock] {
+	return func(yield func(FetchedBlock) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// The block being yielded is also in flight, hence the - 1
+		pending := make(chan chan FetchedBlock, concurrency-1)
+
+		go func() {
+			defer close(pending)
+
+			for blockIdx := range blocks {
+				result := make(chan FetchedBlock, 1)
+
+				select {
+				case pending <- result:
+				case <-ctx.Done():
+					return
+				}
+
+				go func() {
+					indexed, err := ix.ProcessBlock(ctx, blockIdx, accounts)
+					result <- FetchedBlock{
+						Number:  blockIdx,
+						Indexed: indexed,
+						Err:     err,
+					}
+				}()
+			}
+		}()
+
+		for result := range pending {
+			if !yield(<-result) {
+				return
+			}
+		}
+	}
+}
+
+// BlockRange yields every block between from and to (inclusive).
+func BlockRange(from, to uint64) iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		for blockIdx := from; blockIdx <= to; blockIdx++ {
+			if !yield(blockIdx) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/database"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+)
+
+// processTokenTransfers finds the ERC-20, ERC-721 and ERC-1155 transfer events of the block sent
+// from or to one of the accounts. Unlike native transfers, the accounts don't have to be part of
+// the transaction for these, so we ask the node for the logs instead of looking at the receipts.
+func (ix *Indexer) processTokenTransfers(ctx context.Context, block *database.Block, accounts map[string]bool) ([]database.TokenTransfer, []database.NFTTransfer, error) {
+	if len(accounts) == 0 {
+		return nil, nil, nil
+	}
+
+	topics := make([]string, 0, len(accounts))
+	for _, account := range slices.Sorted(maps.Keys(accounts)) {
+		topics = append(topics, data.AddressToTopic(account))
+	}
+
+	blockIdx := *data.NewHexFromUint64(block.Number)
+	erc1155Topics := []string{data.TransferSingleTopic, data.TransferBatchTopic}
+
+	logs, err := ix.node.GetLogsBatch(ctx, []rpc.LogFilter{
+		// ERC-20 and ERC-721 sent by one of the accounts
+		{FromBlock: blockIdx, ToBlock: blockIdx, Topics: [][]string{{data.TransferTopic}, topics}},
+		// ERC-20 and ERC-721 received by one of the accounts
+		{FromBlock: blockIdx, ToBlock: blockIdx, Topics: [][]string{{data.TransferTopic}, nil, topics}},
+		// ERC-1155 sent by one of the accounts, the first topic is the operator
+		{FromBlock: blockIdx, ToBlock: blockIdx, Topics: [][]string{erc1155Topics, nil, topics}},
+		// ERC-1155 received by one of the accounts
+		{FromBlock: blockIdx, ToBlock: blockIdx, Topics: [][]string{erc1155Topics, nil, nil, topics}},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting transfer logs: %w", err)
+	}
+
+	tokenTransfers := []database.TokenTransfer{}
+	nftTransfers := []database.NFTTransfer{}
+	// A transfer between two accounts shows up in two queries
+	seen := map[string]bool{}
+
+	for _, res := range logs {
+		for _, l := range res.Result {
+			key := l.TransactionHash + l.LogIndex
+			if l.Removed || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			var err error
+			switch {
+			case len(l.Topics) == 3 && l.Topics[0] == data.TransferTopic:
+				var transfer database.TokenTransfer
+				transfer, err = decodeTokenTransfer(l, block)
+				if err == nil {
+					log.Printf("Token transfer details: %+v", transfer)
+					tokenTransfers = append(tokenTransfers, transfer)
+				}
+			case len(l.Topics) > 0 && slices.Contains([]string{data.TransferTopic, data.TransferSingleTopic, data.TransferBatchTopic}, l.Topics[0]):
+				var transfers []database.NFTTransfer
+				transfers, err = decodeNFTTransfers(l, block)
+				if err == nil {
+					log.Printf("NFT transfer details: %+v", transfers)
+					nftTransfers = append(nftTransfers, transfers...)
+				}
+			}
+			// Anyone can deploy a contract emitting malformed events, they must not stop the indexer
+			if err != nil {
+				log.Printf("Skipping undecodable log %s of transaction %s: %v", l.LogIndex, l.TransactionHash, err)
+			}
+		}
+	}
+
+	return tokenTransfers, nftTransfers, nil
+}
+
+// decodeTokenTransfer decodes an ERC-20 Transfer(address indexed from, address indexed to, uint256 value).
+func decodeTokenTransfer(l rpc.Log, block *database.Block) (database.TokenTransfer, error) {
+	from, err := data.TopicToAddress(l.Topics[1])
+	if err != nil {
+		return database.TokenTransfer{}, err
+	}
+	to, err := data.TopicToAddress(l.Topics[2])
+	if err != nil {
+		return database.TokenTransfer{}, err
+	}
+
+	words, err := data.Words(l.Data)
+	if err != nil {
+		return database.TokenTransfer{}, err
+	}
+	if len(words) != 1 {
+		return database.TokenTransfer{}, fmt.Errorf("expected 1 word of data, got %d", len(words))
+	}
+
+	logIndex, err := data.NewHexFromString(l.LogIndex)
+	if err != nil {
+		return database.TokenTransfer{}, err
+	}
+
+	return database.TokenTransfer{
+		TransactionHash: l.TransactionHash,
+		LogIndex:        logIndex.Uint64(),
+		Token:           strings.ToLower(l.Address),
+		From:            from,
+		To:              to,
+		Value:           decimal.NewFromBigInt(words[0], 0),
+		BlockIndex:      blockIdx(block),
+		BlockNumber:     block.Number,
+		Timestamp:       block.Timestamp,
+	}, nil
+}
+
+// decodeNFTTransfers decodes the three NFT transfer events:
+//   - ERC-721 Transfer(address indexed from, address indexed to, uint256 indexed tokenId)
+//   - ERC-1155 TransferSingle(address indexed operator, address indexed from, address indexed to, uint256 id, uint256 value)
+//   - ERC-1155 TransferBatch(address indexed operator, address indexed from, address indexed to, uint256[] ids, uint256[] values)
+func decodeNFTTransfers(l rpc.Log, block *database.Block) ([]database.NFTTransfer, error) {
+	if len(l.Topics) != 4 {
+		return nil, fmt.Errorf("expected 4 topics, got %d", len(l.Topics))
+	}
+
+	logIndex, err := data.NewHexFromString(l.LogIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := database.NFTTransfer{
+		TransactionHash: l.TransactionHash,
+		LogIndex:        logIndex.Uint64(),
+		Contract:        strings.ToLower(l.Address),
+		BlockIndex:      blockIdx(block),
+		BlockNumber:     block.Number,
+		Timestamp:       block.Timestamp,
+	}
+
+	if l.Topics[0] == data.TransferTopic {
+		transfer.Standard = "erc721"
+		transfer.Amount = decimal.NewFromInt(1)
+
+		if transfer.From, err = data.TopicToAddress(l.Topics[1]); err != nil {
+			return nil, err
+		}
+		if transfer.To, err = data.TopicToAddress(l.Topics[2]); err != nil {
+			return nil, err
+		}
+
+		tokenID, err := data.NewHexFromString(l.Topics[3])
+		if err != nil {
+			return nil, err
+		}
+		transfer.TokenID = decimal.NewFromBigInt(tokenID.Int, 0)
+
+		return []database.NFTTransfer{transfer}, nil
+	}
+
+	transfer.Standard = "erc1155"
+	if transfer.From, err = data.TopicToAddress(l.Topics[2]); err != nil {
+		return nil, err
+	}
+	if transfer.To, err = data.TopicToAddress(l.Topics[3]); err != nil {
+		return nil, err
+	}
+
+	words, err := data.Words(l.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("expected at least 2 words of data, got %d", len(words))
+	}
+
+	if l.Topics[0] == data.TransferSingleTopic {
+		transfer.TokenID = decimal.NewFromBigInt(words[0], 0)
+		transfer.Amount = decimal.NewFromBigInt(words[1], 0)
+		return []database.NFTTransfer{transfer}, nil
+	}
+
+	ids, err := data.DecodeUintArray(words, words[0])
+	if err != nil {
+		return nil, err
+	}
+	values, err := data.DecodeUintArray(words, words[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != len(values) {
+		return nil, fmt.Errorf("got %d ids but %d values", len(ids), len(values))
+	}
+
+	transfers := make([]database.NFTTransfer, len(ids))
+	for i := range ids {
+		transfers[i] = transfer
+		transfers[i].BatchIndex = uint64(i)
+		transfers[i].TokenID = decimal.NewFromBigInt(ids[i], 0)
+		transfers[i].Amount = decimal.NewFromBigInt(values[i], 0)
+	}
+
+	return transfers, nil
+}
+
+func blockIdx(block *database.Block) string {
+	return data.NewHexFromUint64(block.Number).String()
+}
@@ -2,130 +2,518 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"time"
 
+	"github.com/danilevy1212/baseidx-wt/internal/config"
 	"github.com/danilevy1212/baseidx-wt/internal/data"
 )
 
 type Client struct {
-	BaseURL      string
-	DebugBaseURL string
-	client       *http.Client
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// Deadline of every attempt, on top of the one of the context
+	Timeout time.Duration
+	client  *http.Client
+	base    *endpointPool
+	debug   *endpointPool
 }
 
-func NewClient(baseURL, debugBaseURL string) Client {
-	return Client{
-		BaseURL:      baseURL,
-		DebugBaseURL: debugBaseURL,
-		client:       &http.Client{},
+func NewClient(cfg config.BaseAPIConfig) (*Client, error) {
+	base, err := newEndpointPool("base", cfg.BaseURLs, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	debug, err := newEndpointPool("debug", cfg.BaseDebugURLs, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(cfg.RecordDir, cfg.ReplayDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		MaxRetries:     cfg.MaxRetries,
+		RetryBaseDelay: cfg.RetryBaseDelay,
+		RetryMaxDelay:  cfg.RetryMaxDelay,
+		Timeout:        cfg.Timeout,
+		client:         &http.Client{Transport: transport},
+		base:           base,
+		debug:          debug,
+	}, nil
+}
+
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+	ID      int    `json:"id"`
+}
+
+// BatchCall is a single call of a batch request. Result must be a pointer to the DTO of the
+// method, e.g. *BlockDTO for eth_getBlockByNumber.
+type BatchCall struct {
+	Method string
+	Params []any
+	Result any
+	// Set when the batch went through, but this call didn't get a usable response
+	Error error
+}
+
+func (c *Client) post(ctx context.Context, method string, params []any, target any) error {
+	return c.postTo(ctx, c.base, method, params, target)
+}
+
+func (c *Client) postTo(ctx context.Context, pool *endpointPool, method string, params []any, target any) error {
+	return c.retry(ctx, pool, method, func(ctx context.Context, e *endpoint) error {
+		raw, err := c.send(ctx, e, request{JSONRPC: "2.0", Method: method, Params: params, ID: 1})
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(raw, target)
+	})
+}
+
+// retry runs call until it succeeds, fails with a permanent error or runs out of retries,
+// backing off exponentially with jitter between attempts. Every attempt picks an endpoint
+// from the pool, so a retry goes to another endpoint when there is one, and gets its own
+// Timeout so a hung node doesn't use up the whole context.
+func (c *Client) retry(ctx context.Context, pool *endpointPool, method string, call func(ctx context.Context, e *endpoint) error) error {
+	var last *endpoint
+
+	for attempt := 0; ; attempt++ {
+		e := pool.pick(last)
+
+		err := c.attempt(ctx, e, call)
+		// Cancelled by the caller, not the endpoint's fault
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		pool.report(e, err)
+		if err == nil || !IsRetryable(err) || attempt >= c.MaxRetries {
+			return err
+		}
+		last = e
+
+		delay := c.backoff(attempt)
+
+		// The node knows better than us how long to wait
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			delay = httpErr.RetryAfter
+			e.limiter.Pause(delay)
+		}
+
+		log.Printf("Retrying %s in %s (attempt %d/%d), %s failed: %v", method, delay, attempt+1, c.MaxRetries, e.url, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
 	}
 }
 
-func (c *Client) post(method string, params []any, target any) error {
-	body := map[string]any{
-		"jsonrpc": "2.0",
-		"method":  method,
-		"params":  params,
-		"id":      1,
+func (c *Client) attempt(ctx context.Context, e *endpoint, call func(ctx context.Context, e *endpoint) error) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
 	}
+
+	return call(ctx, e)
+}
+
+// backoff returns a random delay between half and all of RetryBaseDelay * 2^attempt,
+// capped at RetryMaxDelay.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.RetryMaxDelay
+	if attempt < 32 {
+		delay = min(c.RetryBaseDelay<<attempt, c.RetryMaxDelay)
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// decodeResponse unmarshals a single JSON-RPC response into target and returns its error member.
+func decodeResponse(raw []byte, target any) error {
+	res, ok := target.(response)
+	if ok {
+		res.reset()
+	}
+
+	if err := json.Unmarshal(raw, target); err != nil {
+		return fmt.Errorf("unmarshal rpc response: %w", err)
+	}
+
+	if ok {
+		if rpcErr := res.rpcError(); rpcErr != nil {
+			return rpcErr
+		}
+	}
+
+	return nil
+}
+
+// Batch sends all the calls to a base endpoint in a single round trip.
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall) error {
+	return c.batchTo(ctx, c.base, calls)
+}
+
+func (c *Client) batchTo(ctx context.Context, pool *endpointPool, calls []*BatchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	requests := make([]request, len(calls))
+	for i, call := range calls {
+		requests[i] = request{JSONRPC: "2.0", Method: call.Method, Params: call.Params, ID: i + 1}
+	}
+
+	var raw []byte
+	err := c.retry(ctx, pool, "batch", func(ctx context.Context, e *endpoint) error {
+		var err error
+		raw, err = c.send(ctx, e, requests)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The whole batch can be rejected with a single error object instead of an array
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var res Result[json.RawMessage]
+		if err := decodeResponse(trimmed, &res); err != nil {
+			return err
+		}
+		return fmt.Errorf("unexpected rpc batch response: %s", trimmed)
+	}
+
+	var responses []json.RawMessage
+	if err := json.Unmarshal(raw, &responses); err != nil {
+		return fmt.Errorf("unmarshal rpc batch response: %w", err)
+	}
+
+	// Responses can come back in any order, match them to the calls by id
+	byID := make(map[int]json.RawMessage, len(responses))
+	for _, response := range responses {
+		var envelope struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(response, &envelope); err != nil {
+			return fmt.Errorf("unmarshal rpc batch response id: %w", err)
+		}
+		byID[envelope.ID] = response
+	}
+
+	for i, call := range calls {
+		response, ok := byID[i+1]
+		if !ok {
+			call.Error = fmt.Errorf("no response for %s in rpc batch", call.Method)
+			continue
+		}
+
+		call.Error = decodeResponse(response, call.Result)
+	}
+
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, e *endpoint, body any) ([]byte, error) {
 	b, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("marshal rpc body: %w", err)
+		return nil, fmt.Errorf("marshal rpc body: %w", err)
 	}
 
-	resp, err := c.client.Post(c.BaseURL, "application/json", bytes.NewReader(b))
+	if err := e.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("build rpc request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("rpc post failed: %w", err)
+		return nil, fmt.Errorf("rpc post failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("rpc error: status code %d", resp.StatusCode)
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
 	}
 
 	raw, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("read body: %w", err)
+		return nil, fmt.Errorf("read body: %w", err)
 	}
 
-	if err := json.Unmarshal(raw, target); err != nil {
-		return fmt.Errorf("unmarshal rpc response: %w", err)
-	}
-	return nil
+	return raw, nil
 }
 
-func (c *Client) GetBlockByNumber(block data.Hex, full bool) (*BlockDTO, error) {
+func (c *Client) GetBlockByNumber(ctx context.Context, block data.Hex, full bool) (*BlockDTO, error) {
 	var res BlockDTO
-	err := c.post("eth_getBlockByNumber", []any{block.String(), full}, &res)
+	err := c.post(ctx, "eth_getBlockByNumber", []any{block.String(), full}, &res)
 	if err != nil {
 		return nil, err
 	}
+	if res.Result.Number == "" {
+		return nil, fmt.Errorf("block %s: %w", block.String(), ErrNullResult)
+	}
 	return &res, nil
 }
 
-func (c *Client) GetBalance(addr string) (*BalanceDTO, error) {
+// GetBlockHeaderByNumber is like GetBlockByNumber, but only returns the transaction hashes,
+// which we don't decode.
+func (c *Client) GetBlockHeaderByNumber(ctx context.Context, block data.Hex) (*BlockHeaderDTO, error) {
+	var res BlockHeaderDTO
+	err := c.post(ctx, "eth_getBlockByNumber", []any{block.String(), false}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Result.Number == "" {
+		return nil, fmt.Errorf("block %s: %w", block.String(), ErrNullResult)
+	}
+	return &res, nil
+}
+
+// GetBalance returns the native balance of the address at the end of the block.
+func (c *Client) GetBalance(ctx context.Context, addr string, block data.Hex) (*BalanceDTO, error) {
 	var res BalanceDTO
-	err := c.post("eth_getBalance", []any{addr, "latest"}, &res)
+	err := c.post(ctx, "eth_getBalance", []any{addr, block.String()}, &res)
 	if err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
-func (c *Client) GetBlockReceipts(block data.Hex) (*BlockReceiptsDTO, error) {
+func (c *Client) GetBlockReceipts(ctx context.Context, block data.Hex) (*BlockReceiptsDTO, error) {
 	var res BlockReceiptsDTO
-	err := c.post("eth_getBlockReceipts", []any{block.String()}, &res)
+	err := c.post(ctx, "eth_getBlockReceipts", []any{block.String()}, &res)
 	if err != nil {
 		return nil, err
 	}
+	// A block without transactions has an empty list, not null
+	if res.Result == nil {
+		return nil, fmt.Errorf("receipts of block %s: %w", block.String(), ErrNullResult)
+	}
 	return &res, nil
 }
 
-func (c *Client) GetLastestBlock() (*LatestBlockDTO, error) {
+func (c *Client) GetLastestBlock(ctx context.Context) (*LatestBlockDTO, error) {
 	var res LatestBlockDTO
-	err := c.post("eth_blockNumber", nil, &res)
+	err := c.post(ctx, "eth_blockNumber", nil, &res)
 	if err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
-func (c *Client) GetTransactionCallTrace(transactionHash string) (*GetTransactionCallTraceDTO, error) {
-	payload := map[string]any{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  "debug_traceTransaction",
-		"params": []any{
-			transactionHash,
-			map[string]any{
-				"tracer":       "callTracer",
-				"tracerConfig": map[string]any{"onlyTopLevel": false},
-			},
-		},
+// CheckHealth asks every endpoint for its latest block, so the ones lagging behind the others
+// stop getting calls until they catch up. Endpoints that don't answer count as failures.
+func (c *Client) CheckHealth(ctx context.Context) {
+	for _, pool := range []*endpointPool{c.base, c.debug} {
+		// Nothing to fail over to
+		if len(pool.endpoints) < 2 {
+			continue
+		}
+
+		for _, e := range pool.endpoints {
+			var head *data.Hex
+
+			err := c.attempt(ctx, e, func(ctx context.Context, e *endpoint) error {
+				raw, err := c.send(ctx, e, request{JSONRPC: "2.0", Method: "eth_blockNumber", ID: 1})
+				if err != nil {
+					return err
+				}
+
+				var res LatestBlockDTO
+				if err := decodeResponse(raw, &res); err != nil {
+					return err
+				}
+
+				head, err = data.NewHexFromString(res.Result)
+				return err
+			})
+			if ctx.Err() != nil {
+				return
+			}
+
+			pool.report(e, err)
+			if err != nil {
+				log.Printf("Health check of %s endpoint %s failed: %v", pool.name, e.url, err)
+				continue
+			}
+
+			pool.setHead(e, head.Uint64())
+		}
 	}
+}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
+// TokenMetadata holds the optional ERC-20 metadata functions, a token that doesn't implement
+// one of them gets it left empty.
+type TokenMetadata struct {
+	Name     string
+	Symbol   string
+	Decimals *int32
+}
+
+// GetTokenMetadata calls name(), symbol() and decimals() on the token contract in a single round trip.
+func (c *Client) GetTokenMetadata(ctx context.Context, token string) (*TokenMetadata, error) {
+	var name, symbol, decimals CallDTO
+
+	call := func(selector string, result *CallDTO) *BatchCall {
+		return &BatchCall{
+			Method: "eth_call",
+			Params: []any{map[string]any{"to": token, "data": selector}, "latest"},
+			Result: result,
+		}
+	}
+	calls := []*BatchCall{
+		call(data.NameSelector, &name),
+		call(data.SymbolSelector, &symbol),
+		call(data.DecimalsSelector, &decimals),
+	}
+	if err := c.Batch(ctx, calls); err != nil {
 		return nil, err
 	}
 
-	resp, err := http.Post(
-		c.DebugBaseURL,
-		"application/json",
-		bytes.NewReader(jsonData),
-	)
-	if err != nil {
+	// Reverts mean the function isn't implemented, so the metadata is known to be empty and can
+	// be cached. Anything else means we don't know, and it has to be fetched again later.
+	for _, call := range calls {
+		var rpcErr *Error
+		if call.Error != nil && (!errors.As(call.Error, &rpcErr) || !rpcErr.Reverted()) {
+			return nil, call.Error
+		}
+	}
+
+	var metadata TokenMetadata
+	if calls[0].Error == nil {
+		metadata.Name, _ = data.DecodeString(name.Result)
+	}
+	if calls[1].Error == nil {
+		metadata.Symbol, _ = data.DecodeString(symbol.Result)
+	}
+	if calls[2].Error == nil {
+		if words, err := data.Words(decimals.Result); err == nil && len(words) == 1 && words[0].IsInt64() && words[0].Int64() <= 255 {
+			d := int32(words[0].Int64())
+			metadata.Decimals = &d
+		}
+	}
+
+	return &metadata, nil
+}
+
+// LogFilter selects the logs of eth_getLogs. Each position of Topics matches any of the given
+// values, nil matches anything.
+type LogFilter struct {
+	FromBlock data.Hex
+	ToBlock   data.Hex
+	Address   []string
+	Topics    [][]string
+}
+
+func (f LogFilter) params() []any {
+	filter := map[string]any{
+		"fromBlock": f.FromBlock.String(),
+		"toBlock":   f.ToBlock.String(),
+	}
+	if len(f.Address) > 0 {
+		filter["address"] = f.Address
+	}
+	if len(f.Topics) > 0 {
+		topics := make([]any, len(f.Topics))
+		for i, topic := range f.Topics {
+			if topic != nil {
+				topics[i] = topic
+			}
+		}
+		filter["topics"] = topics
+	}
+	return []any{filter}
+}
+
+// GetLogsBatch runs several eth_getLogs in a single round trip, the results keep the order of the filters.
+func (c *Client) GetLogsBatch(ctx context.Context, filters []LogFilter) ([]*LogsDTO, error) {
+	results := make([]*LogsDTO, len(filters))
+	calls := make([]*BatchCall, len(filters))
+	for i, filter := range filters {
+		results[i] = &LogsDTO{}
+		calls[i] = &BatchCall{Method: "eth_getLogs", Params: filter.params(), Result: results[i]}
+	}
+
+	if err := c.Batch(ctx, calls); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
+	for _, call := range calls {
+		if call.Error != nil {
+			return nil, call.Error
+		}
+	}
+
+	return results, nil
+}
+
+func callTracerParams(transactionHash string) []any {
+	return []any{
+		transactionHash,
+		map[string]any{
+			"tracer":       "callTracer",
+			"tracerConfig": map[string]any{"onlyTopLevel": false},
+		},
+	}
+}
+
+func (c *Client) GetTransactionCallTrace(ctx context.Context, transactionHash string) (*GetTransactionCallTraceDTO, error) {
 	var traceDTO GetTransactionCallTraceDTO
-	if err := json.NewDecoder(resp.Body).Decode(&traceDTO); err != nil {
+	err := c.postTo(ctx, c.debug, "debug_traceTransaction", callTracerParams(transactionHash), &traceDTO)
+	if err != nil {
 		return nil, err
 	}
-
 	return &traceDTO, nil
 }
+
+// GetTransactionCallTraces traces several transactions in a single round trip to the debug node.
+// Transactions whose trace failed are left out of the result.
+func (c *Client) GetTransactionCallTraces(ctx context.Context, transactionHashes []string) (map[string]*GetTransactionCallTraceDTO, error) {
+	traces := make([]GetTransactionCallTraceDTO, len(transactionHashes))
+	calls := make([]*BatchCall, len(transactionHashes))
+	for i, hash := range transactionHashes {
+		calls[i] = &BatchCall{Method: "debug_traceTransaction", Params: callTracerParams(hash), Result: &traces[i]}
+	}
+
+	if err := c.batchTo(ctx, c.debug, calls); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*GetTransactionCallTraceDTO, len(transactionHashes))
+	for i, call := range calls {
+		if call.Error == nil {
+			result[transactionHashes[i]] = &traces[i]
+		}
+	}
+
+	return result, nil
+}
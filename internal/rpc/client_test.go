@@ -0,0 +1,76 @@
+package rpc_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+// abiString encodes the return value of a function returning a short string.
+func abiString(s string) string {
+	encoded := fmt.Sprintf("%x", s)
+	return fmt.Sprintf("0x%064x%064x%s%s", 0x20, len(s), encoded, strings.Repeat("0", 64-len(encoded)))
+}
+
+func TestGetTokenMetadata(t *testing.T) {
+	usdc := rpctest.Address(10)
+	noName := rpctest.Address(11)
+	reverting := rpctest.Address(12)
+
+	chain := rpctest.NewChain(10, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	chain.Contracts[usdc] = map[string]string{
+		data.NameSelector:     abiString("USD Coin"),
+		data.SymbolSelector:   abiString("USDC"),
+		data.DecimalsSelector: fmt.Sprintf("0x%064x", 6),
+	}
+	chain.Contracts[noName] = map[string]string{
+		data.SymbolSelector: abiString("NN"),
+	}
+
+	node := rpctest.NewNode(t, chain)
+	client, err := rpc.NewClient(node.Config())
+	if err != nil {
+		t.Fatalf("creating rpc client: %v", err)
+	}
+	ctx := context.Background()
+
+	six := int32(6)
+	tests := []struct {
+		name  string
+		token string
+		want  rpc.TokenMetadata
+	}{
+		{"complete", usdc, rpc.TokenMetadata{Name: "USD Coin", Symbol: "USDC", Decimals: &six}},
+		{"name and decimals revert", noName, rpc.TokenMetadata{Symbol: "NN"}},
+		// Known to have no metadata, so it can be cached
+		{"everything reverts", reverting, rpc.TokenMetadata{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetTokenMetadata(ctx, tt.token)
+			if err != nil {
+				t.Fatalf("GetTokenMetadata: %v", err)
+			}
+			if got.Name != tt.want.Name || got.Symbol != tt.want.Symbol || (got.Decimals == nil) != (tt.want.Decimals == nil) ||
+				(got.Decimals != nil && *got.Decimals != *tt.want.Decimals) {
+				t.Errorf("metadata = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("node failing", func(t *testing.T) {
+		// More than the retries of the client
+		node.Fail("eth_call", 30)
+
+		if _, err := client.GetTokenMetadata(ctx, usdc); err == nil {
+			t.Fatal("GetTokenMetadata succeeded while the node failed, the empty metadata would be cached")
+		}
+	})
+}
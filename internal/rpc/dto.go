@@ -1,7 +1,23 @@
 package rpc
 
 type Result[T any] struct {
-	Result T `json:"result"`
+	Result T      `json:"result"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+func (r *Result[T]) rpcError() *Error {
+	return r.Error
+}
+
+func (r *Result[T]) reset() {
+	*r = Result[T]{}
+}
+
+// response is implemented by every DTO, so the client can check the error member.
+type response interface {
+	rpcError() *Error
+	// Clears what a previous attempt decoded, a successful response has no error member to overwrite it
+	reset()
 }
 
 // eth_blockNumber
@@ -18,17 +34,27 @@ type Receipt struct {
 	EffectiveGasPrice string  `json:"effectiveGasPrice"` // FffectiveGasPrice * GasUsed + l1Fee = fee
 	TransactionHash   string  `json:"transactionHash"`   // Matches with Transaction.Hash
 	L1Fee             *string `json:"l1Fee,omitempty"`   // Can be empty for system level transactions
+	Logs              []Log   `json:"logs"`
 }
 
 // eth_getBlockByNumber
 type BlockDTO = Result[BlockData]
 
 type BlockData struct {
-	Number       string        `json:"number"`
-	Timestamp    string        `json:"timestamp"` // UTC unix timestamp in Hex, use time.Unix(hex.NewHexFromString().Int64(), 0)
+	BlockHeader
 	Transactions []Transaction `json:"transactions"`
 }
 
+// eth_getBlockByNumber without the full transactions
+type BlockHeaderDTO = Result[BlockHeader]
+
+type BlockHeader struct {
+	Number     string `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"` // Has to match the hash of the previous block, otherwise there was a reorg
+	Timestamp  string `json:"timestamp"`  // UTC unix timestamp in Hex, use time.Unix(hex.NewHexFromString().Int64(), 0)
+}
+
 type Transaction struct {
 	From  string `json:"from"`
 	To    string `json:"to,omitempty"`
@@ -49,3 +75,19 @@ type CallTrace struct {
 }
 
 type GetTransactionCallTraceDTO = Result[CallTrace]
+
+// eth_getLogs
+type LogsDTO = Result[[]Log]
+
+type Log struct {
+	Address         string   `json:"address"` // Contract that emitted the event
+	Topics          []string `json:"topics"`  // Topics[0] is the event signature, the rest are the indexed arguments
+	Data            string   `json:"data"`    // Non indexed arguments, ABI encoded
+	BlockNumber     string   `json:"blockNumber"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+	Removed         bool     `json:"removed"` // True if the log was dropped by a reorg
+}
+
+// eth_call
+type CallDTO = Result[string]
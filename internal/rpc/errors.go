@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Error is the error member of a JSON-RPC response. Nodes return it with HTTP 200, so it has
+// to be checked on every response.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("rpc error %d: %s (%s)", e.Code, e.Message, string(e.Data))
+	}
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// Retryable tells if the same call could succeed later. Malformed requests, unknown methods
+// and reverted executions never will.
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case -32603, // Internal error
+		-32002, // Resource unavailable
+		-32005: // Limit exceeded
+		return true
+	case -32000:
+		// Generic server error, used for reverts and invalid transactions too, so only the
+		// messages of a node that is behind or overloaded are worth retrying
+		message := strings.ToLower(e.Message)
+		for _, transient := range transientMessages {
+			if strings.Contains(message, transient) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Reverted tells if the call was executed and reverted, which is what calling a function the
+// contract doesn't implement does. Nodes use code 3 when the revert has data, -32000 otherwise.
+func (e *Error) Reverted() bool {
+	return e.Code == 3 || (e.Code == -32000 && strings.Contains(strings.ToLower(e.Message), "execution reverted"))
+}
+
+// transientMessages are the -32000 errors nodes return when they are behind or overloaded.
+var transientMessages = []string{
+	"header not found",
+	"unknown block",
+	"timeout",
+	"timed out",
+	"rate limit",
+	"too many requests",
+}
+
+// HTTPError is returned when the node doesn't answer with HTTP 200.
+type HTTPError struct {
+	StatusCode int
+	// From the Retry-After header, 0 if there was none
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("rpc error: status code %d", e.StatusCode)
+}
+
+// Retryable is true for rate limiting and server side failures.
+func (e *HTTPError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// parseRetryAfter supports both forms of the header, delay in seconds and HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
+
+// ErrNullResult is returned when the node has nothing for the call, e.g. a block past the head.
+var ErrNullResult = errors.New("rpc returned a null result")
+
+// IsRetryable classifies errors returned by the client as retryable or permanent.
+func IsRetryable(err error) bool {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Retryable()
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Retryable()
+	}
+
+	// The attempt ran out of time, cancellations by the caller are checked before retrying
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	// http.Client wraps every failure of the transport in a *url.Error, which is a net.Error
+	// too, so look at what it wraps instead
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// The node went away, or is restarting
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// The node may not have caught up yet
+	return errors.Is(err, ErrNullResult)
+}
@@ -0,0 +1,57 @@
+package rpc_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+)
+
+func TestIsRetryable(t *testing.T) {
+	// How http.Client.Do reports a failure of the transport
+	transport := func(err error) error {
+		return fmt.Errorf("rpc call: %w", &url.Error{Op: "Post", URL: "https://node.example", Err: err})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"internal error", &rpc.Error{Code: -32603, Message: "internal error"}, true},
+		{"limit exceeded", &rpc.Error{Code: -32005, Message: "limit exceeded"}, true},
+		{"header not found", &rpc.Error{Code: -32000, Message: "header not found"}, true},
+		{"unknown block", &rpc.Error{Code: -32000, Message: "Unknown block"}, true},
+		{"reverted", &rpc.Error{Code: -32000, Message: "execution reverted"}, false},
+		{"missing transaction", &rpc.Error{Code: -32000, Message: "transaction 0x1 not found"}, false},
+		{"invalid params", &rpc.Error{Code: -32602, Message: "invalid argument"}, false},
+		{"rate limited", &rpc.HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{"bad gateway", &rpc.HTTPError{StatusCode: http.StatusBadGateway}, true},
+		{"unauthorized", &rpc.HTTPError{StatusCode: http.StatusUnauthorized}, false},
+		{"null result", rpc.ErrNullResult, true},
+		{"connection refused", transport(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"connection reset", transport(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"timeout", transport(&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}), true},
+		{"eof", transport(io.EOF), true},
+		{"unexpected eof", transport(io.ErrUnexpectedEOF), true},
+		{"unknown host", transport(&net.DNSError{Err: "no such host", Name: "node.example", IsNotFound: true}), false},
+		{"replay miss", transport(fmt.Errorf("no recorded response for {}: %w", fs.ErrNotExist)), false},
+		{"other", errors.New("something else"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rpc.IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+)
+
+const (
+	// Always use the first healthy endpoint, in the configured order
+	StrategyFailover = "failover"
+	// Spread the calls over the healthy endpoints, proportionally to their weight
+	StrategyRoundRobin = "round-robin"
+)
+
+type endpoint struct {
+	url     string
+	weight  int
+	limiter *rateLimiter
+
+	// Guarded by the pool mutex
+	failures       int
+	unhealthyUntil time.Time
+	head           uint64
+	currentWeight  int
+}
+
+// endpointPool holds the endpoints of a role (base or debug) and tracks their health.
+type endpointPool struct {
+	name        string
+	strategy    string
+	maxFailures int
+	cooldown    time.Duration
+	maxLag      uint64
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+}
+
+func newEndpointPool(name string, urls []string, cfg config.BaseAPIConfig) (*endpointPool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no %s endpoints configured", name)
+	}
+
+	pool := &endpointPool{
+		name:        name,
+		strategy:    cfg.Strategy,
+		maxFailures: max(cfg.MaxFailures, 1),
+		cooldown:    cfg.UnhealthyCooldown,
+		maxLag:      cfg.MaxLag,
+	}
+
+	for _, raw := range urls {
+		url, weight, err := parseEndpoint(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		pool.endpoints = append(pool.endpoints, &endpoint{
+			url:     url,
+			weight:  weight,
+			limiter: newRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		})
+	}
+
+	return pool, nil
+}
+
+// parseEndpoint splits "url|weight", the weight defaults to 1.
+func parseEndpoint(raw string) (string, int, error) {
+	url, weightStr, found := strings.Cut(strings.TrimSpace(raw), "|")
+	if url == "" {
+		return "", 0, fmt.Errorf("empty rpc endpoint in %q", raw)
+	}
+	if !found {
+		return url, 1, nil
+	}
+
+	weight, err := strconv.Atoi(weightStr)
+	if err != nil || weight < 1 {
+		return "", 0, fmt.Errorf("invalid weight for rpc endpoint %q", raw)
+	}
+
+	return url, weight, nil
+}
+
+// pick returns the endpoint for the next call. Unhealthy and lagging endpoints are avoided,
+// and so is the endpoint that just failed if there is another one. If every endpoint is
+// unhealthy, we still have to try one of them.
+func (p *endpointPool) pick(avoid *endpoint) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	best := p.bestHead()
+
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if e == avoid || now.Before(e.unhealthyUntil) || p.lagging(e, best) {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+
+	if len(candidates) == 0 {
+		if avoid != nil && len(p.endpoints) > 1 {
+			for _, e := range p.endpoints {
+				if e != avoid {
+					candidates = append(candidates, e)
+				}
+			}
+		} else {
+			candidates = p.endpoints
+		}
+	}
+
+	if p.strategy != StrategyRoundRobin {
+		return candidates[0]
+	}
+
+	// Smooth weighted round robin, so heavier endpoints don't get their calls in bursts
+	total := 0
+	var chosen *endpoint
+	for _, e := range candidates {
+		e.currentWeight += e.weight
+		total += e.weight
+		if chosen == nil || e.currentWeight > chosen.currentWeight {
+			chosen = e
+		}
+	}
+	chosen.currentWeight -= total
+
+	return chosen
+}
+
+func (p *endpointPool) bestHead() uint64 {
+	var best uint64
+	for _, e := range p.endpoints {
+		best = max(best, e.head)
+	}
+	return best
+}
+
+func (p *endpointPool) lagging(e *endpoint, best uint64) bool {
+	// Only endpoints we know the head of can lag behind
+	return e.head > 0 && e.head+p.maxLag < best
+}
+
+// report records the outcome of a call. Only retryable errors count against the endpoint,
+// a permanent error means the call itself was wrong.
+func (p *endpointPool) report(e *endpoint, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		e.failures = 0
+		return
+	}
+
+	if !IsRetryable(err) {
+		return
+	}
+
+	e.failures++
+	if e.failures >= p.maxFailures && len(p.endpoints) > 1 {
+		log.Printf("Marking %s endpoint %s as unhealthy for %s after %d failures: %v", p.name, e.url, p.cooldown, e.failures, err)
+		e.unhealthyUntil = time.Now().Add(p.cooldown)
+		e.failures = 0
+	}
+}
+
+// setHead records the latest block reported by the endpoint, to detect endpoints lagging behind.
+func (p *endpointPool) setHead(e *endpoint, head uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e.head = head
+}
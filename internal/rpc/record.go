@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// fixture is a recorded JSON-RPC exchange. There is one file per distinct request body, so
+// the order in which the calls are made doesn't matter when replaying them.
+type fixture struct {
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+// fixtureName is <method>-<hash of the body>.json, batches are named after their first call.
+func fixtureName(body []byte) string {
+	method := "call"
+
+	var single struct {
+		Method string `json:"method"`
+	}
+	var batch []struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &single) == nil && single.Method != "" {
+		method = single.Method
+	} else if json.Unmarshal(body, &batch) == nil && len(batch) > 0 {
+		method = "batch_" + batch[0].Method
+	}
+
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("%s-%x.json", method, sum[:8])
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// recordingTransport saves every successful exchange with the node to dir.
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	// Failures are retried, only what the client ends up using is worth keeping
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+
+	// Keep the files readable, like the requests of the Bruno collection
+	var request, response bytes.Buffer
+	if err := json.Indent(&request, body, "", "  "); err != nil {
+		return nil, fmt.Errorf("record request: %w", err)
+	}
+	if err := json.Indent(&response, raw, "", "  "); err != nil {
+		return nil, fmt.Errorf("record response: %w", err)
+	}
+
+	b, err := json.MarshalIndent(fixture{Request: request.Bytes(), Response: response.Bytes()}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("record fixture: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, fixtureName(body)), append(b, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("record fixture: %w", err)
+	}
+
+	return resp, nil
+}
+
+// replayTransport answers from the exchanges recorded in dir, without touching the network.
+// A request that wasn't recorded fails, and isn't retried.
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fixtureName(body)
+	b, err := os.ReadFile(filepath.Join(t.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("no recorded response for %s: %w", body, err)
+	}
+
+	var f fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", name, err)
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(f.Response)),
+		ContentLength: int64(len(f.Response)),
+		Request:       req,
+	}, nil
+}
+
+// newTransport records to RecordDir or replays from ReplayDir when they are set.
+func newTransport(recordDir, replayDir string) (http.RoundTripper, error) {
+	switch {
+	case replayDir != "":
+		return &replayTransport{dir: replayDir}, nil
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create record dir: %w", err)
+		}
+		return &recordingTransport{dir: recordDir, next: http.DefaultTransport}, nil
+	default:
+		return http.DefaultTransport, nil
+	}
+}
@@ -0,0 +1,82 @@
+package rpc_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc/rpctest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	chain := rpctest.NewChain(10, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	chain.Next(&rpctest.Tx{From: rpctest.Address(1), To: rpctest.Address(2), Input: "0x1234", Value: rpctest.Wei(10), Calls: []rpc.CallTrace{
+		{From: rpctest.Address(2), To: rpctest.Address(1), Value: "0x5", Input: "0x"},
+	}})
+	tx := chain.Block(10).Transactions[0]
+
+	node := rpctest.NewNode(t, chain)
+	ctx := context.Background()
+	block := *data.NewHexFromUint64(10)
+
+	type exchange struct {
+		block    *rpc.BlockDTO
+		receipts *rpc.BlockReceiptsDTO
+		logs     []*rpc.LogsDTO
+		trace    *rpc.GetTransactionCallTraceDTO
+		latest   *rpc.LatestBlockDTO
+	}
+
+	run := func(client *rpc.Client) exchange {
+		t.Helper()
+
+		var e exchange
+		var err error
+		if e.block, err = client.GetBlockByNumber(ctx, block, true); err != nil {
+			t.Fatalf("GetBlockByNumber: %v", err)
+		}
+		if e.receipts, err = client.GetBlockReceipts(ctx, block); err != nil {
+			t.Fatalf("GetBlockReceipts: %v", err)
+		}
+		// A batch
+		if e.logs, err = client.GetLogsBatch(ctx, []rpc.LogFilter{{FromBlock: block, ToBlock: block}, {FromBlock: block, ToBlock: block, Address: []string{rpctest.Address(3)}}}); err != nil {
+			t.Fatalf("GetLogsBatch: %v", err)
+		}
+		if e.trace, err = client.GetTransactionCallTrace(ctx, tx.Hash); err != nil {
+			t.Fatalf("GetTransactionCallTrace: %v", err)
+		}
+		if e.latest, err = client.GetLastestBlock(ctx); err != nil {
+			t.Fatalf("GetLastestBlock: %v", err)
+		}
+		return e
+	}
+
+	cfg := node.Config()
+	cfg.RecordDir = t.TempDir()
+	recorder, err := rpc.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("creating recording client: %v", err)
+	}
+	recorded := run(recorder)
+
+	// Replaying must not need the node
+	node.Close()
+
+	cfg.ReplayDir, cfg.RecordDir = cfg.RecordDir, ""
+	replayer, err := rpc.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("creating replay client: %v", err)
+	}
+	replayed := run(replayer)
+
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("replayed %+v, recorded %+v", replayed, recorded)
+	}
+
+	if _, err := replayer.GetBalance(ctx, rpctest.Address(1), block); err == nil {
+		t.Error("a call that wasn't recorded was answered")
+	}
+}
@@ -0,0 +1,230 @@
+// Package rpctest provides a fake Base node for tests, serving scripted chains over JSON-RPC.
+package rpctest
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+)
+
+// Tx is a scripted transaction. Empty fields get sensible defaults when the block is added.
+type Tx struct {
+	Hash  string // Derived from the block hash and the position if empty
+	From  string
+	To    string
+	Value *big.Int
+	Input string // "0x" (a plain transfer) if empty
+
+	Failed            bool     // Receipt status 0x0, the value doesn't move but the fee is paid
+	GasUsed           *big.Int // 21000 if nil
+	EffectiveGasPrice *big.Int // 1 gwei if nil
+	L1Fee             *big.Int // Left out of the receipt if nil, like system transactions
+
+	Calls []rpc.CallTrace // Internal calls, served by debug_traceTransaction
+	Logs  []rpc.Log       // Only Address, Topics and Data are needed, the rest is filled in
+}
+
+// Fee is what the sender pays for the transaction, whether it went through or not.
+func (tx *Tx) Fee() *big.Int {
+	fee := new(big.Int).Mul(tx.GasUsed, tx.EffectiveGasPrice)
+	if tx.L1Fee != nil {
+		fee.Add(fee, tx.L1Fee)
+	}
+	return fee
+}
+
+type Block struct {
+	Number       uint64
+	Hash         string
+	ParentHash   string
+	Timestamp    time.Time
+	Transactions []*Tx
+}
+
+// Chain is a scripted sequence of blocks. Use Fork to script a reorg.
+type Chain struct {
+	// Balances before the first block, used by eth_getBalance
+	Genesis map[string]*big.Int
+	Blocks  []*Block
+	// What eth_call returns, by contract and selector. Any other call reverts
+	Contracts map[string]map[string]string
+
+	first     uint64
+	blockTime time.Duration
+	salt      string
+}
+
+// NewChain starts an empty chain whose first block will be first (at least 1), mined at start.
+// Blocks come every 2 seconds, like on Base.
+func NewChain(first uint64, start time.Time) *Chain {
+	return &Chain{
+		Genesis:   map[string]*big.Int{},
+		Contracts: map[string]map[string]string{},
+		first:     first,
+		blockTime: 2 * time.Second,
+		salt:      "canonical",
+		Blocks:    []*Block{{Number: first - 1, Hash: blockHash(first-1, "genesis"), Timestamp: start.Add(-2 * time.Second)}},
+	}
+}
+
+// Next mines a block with the transactions on top of the chain and returns it.
+func (c *Chain) Next(txs ...*Tx) *Block {
+	parent := c.Blocks[len(c.Blocks)-1]
+	number := parent.Number + 1
+
+	block := &Block{
+		Number:       number,
+		Hash:         blockHash(number, c.salt),
+		ParentHash:   parent.Hash,
+		Timestamp:    parent.Timestamp.Add(c.blockTime),
+		Transactions: txs,
+	}
+
+	for i, tx := range txs {
+		if tx.Hash == "" {
+			tx.Hash = fmt.Sprintf("0x%064x", new(big.Int).Add(new(big.Int).Lsh(hashInt(block.Hash), 16), big.NewInt(int64(i))))
+		}
+		tx.From = strings.ToLower(tx.From)
+		tx.To = strings.ToLower(tx.To)
+		if tx.Value == nil {
+			tx.Value = new(big.Int)
+		}
+		if tx.Input == "" {
+			tx.Input = "0x"
+		}
+		if tx.GasUsed == nil {
+			tx.GasUsed = big.NewInt(21_000)
+		}
+		if tx.EffectiveGasPrice == nil {
+			tx.EffectiveGasPrice = big.NewInt(1_000_000_000)
+		}
+	}
+
+	c.Blocks = append(c.Blocks, block)
+
+	return block
+}
+
+// Empty mines n blocks without transactions.
+func (c *Chain) Empty(n int) {
+	for range n {
+		c.Next()
+	}
+}
+
+// Fork returns a copy of the chain up to the block before number, the blocks mined on top of
+// it get different hashes than the ones of the original chain.
+func (c *Chain) Fork(number uint64, name string) *Chain {
+	fork := &Chain{
+		Genesis:   c.Genesis,
+		Contracts: c.Contracts,
+		first:     c.first,
+		blockTime: c.blockTime,
+		salt:      name,
+	}
+
+	for _, block := range c.Blocks {
+		if block.Number >= number {
+			break
+		}
+		fork.Blocks = append(fork.Blocks, block)
+	}
+
+	return fork
+}
+
+// Head is the number of the last block of the chain.
+func (c *Chain) Head() uint64 {
+	return c.Blocks[len(c.Blocks)-1].Number
+}
+
+// Block returns the block with the number, if the chain has it. The block before the first
+// one is the genesis block, it has no transactions.
+func (c *Chain) Block(number uint64) *Block {
+	if number+1 < c.first || number > c.Head() {
+		return nil
+	}
+	return c.Blocks[number+1-c.first]
+}
+
+// Balance replays the chain up to the block (inclusive): fees are always paid, the value of
+// the transaction and of its internal calls only moves if it went through.
+func (c *Chain) Balance(address string, number uint64) *big.Int {
+	address = strings.ToLower(address)
+
+	balance := new(big.Int)
+	if genesis, ok := c.Genesis[address]; ok {
+		balance.Set(genesis)
+	}
+
+	for _, block := range c.Blocks[1:] {
+		if block.Number > number {
+			break
+		}
+
+		for _, tx := range block.Transactions {
+			if tx.From == address {
+				balance.Sub(balance, tx.Fee())
+			}
+			if tx.Failed {
+				continue
+			}
+
+			moveValue(balance, address, tx.From, tx.To, tx.Value)
+			walkCalls(tx.Calls, func(call rpc.CallTrace) {
+				value, ok := new(big.Int).SetString(strings.TrimPrefix(call.Value, "0x"), 16)
+				if ok {
+					moveValue(balance, address, strings.ToLower(call.From), strings.ToLower(call.To), value)
+				}
+			})
+		}
+	}
+
+	return balance
+}
+
+func moveValue(balance *big.Int, address, from, to string, value *big.Int) {
+	if from == address {
+		balance.Sub(balance, value)
+	}
+	if to == address {
+		balance.Add(balance, value)
+	}
+}
+
+func walkCalls(calls []rpc.CallTrace, visit func(rpc.CallTrace)) {
+	for _, call := range calls {
+		visit(call)
+		walkCalls(call.Calls, visit)
+	}
+}
+
+func blockHash(number uint64, salt string) string {
+	h := new(big.Int).SetUint64(number)
+	for _, b := range []byte(salt) {
+		h.Mul(h, big.NewInt(31))
+		h.Add(h, big.NewInt(int64(b)))
+	}
+	h.Lsh(h, 64)
+	h.Add(h, new(big.Int).SetUint64(number))
+	return fmt.Sprintf("0x%064x", h)
+}
+
+func hashInt(hash string) *big.Int {
+	h, _ := new(big.Int).SetString(strings.TrimPrefix(hash, "0x"), 16)
+	// Keep the room for the position in the block
+	return h.Rsh(h, 16)
+}
+
+// Address returns a deterministic address for tests, e.g. Address(1) is 0x00..01.
+func Address(n uint64) string {
+	return fmt.Sprintf("0x%040x", n)
+}
+
+// Wei is a shorthand for big.NewInt in tests.
+func Wei(n int64) *big.Int {
+	return big.NewInt(n)
+}
@@ -0,0 +1,438 @@
+package rpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danilevy1212/baseidx-wt/internal/config"
+	"github.com/danilevy1212/baseidx-wt/internal/data"
+	"github.com/danilevy1212/baseidx-wt/internal/rpc"
+)
+
+// Node is a fake Base node serving a scripted chain. It answers eth_blockNumber,
+// eth_getBlockByNumber, eth_getBlockReceipts, eth_getBalance, eth_getLogs, eth_call and
+// debug_traceTransaction, in single and batch requests.
+type Node struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	chain    *Chain
+	failures map[string]int
+	nulls    map[string]int
+	calls    map[string]int
+}
+
+// NewNode starts a node serving the chain, it is shut down when the test ends.
+func NewNode(t testing.TB, chain *Chain) *Node {
+	n := &Node{
+		chain:    chain,
+		failures: map[string]int{},
+		nulls:    map[string]int{},
+		calls:    map[string]int{},
+	}
+	n.Server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+	t.Cleanup(n.Close)
+
+	return n
+}
+
+// SetChain swaps the chain served, e.g. with a Fork of it to simulate a reorg.
+func (n *Node) SetChain(chain *Chain) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.chain = chain
+}
+
+// Fail makes the next times calls of the method fail with a retryable internal error.
+func (n *Node) Fail(method string, times int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.failures[method] = times
+}
+
+// Null makes the next times calls of the method answer a null result, like a node lagging behind.
+func (n *Node) Null(method string, times int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.nulls[method] = times
+}
+
+// Calls returns how many times the method was called, counting every call of a batch.
+func (n *Node) Calls(method string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.calls[method]
+}
+
+// Config points both the base and the debug endpoints to the node, with fast retries.
+func (n *Node) Config() config.BaseAPIConfig {
+	return config.BaseAPIConfig{
+		BaseURLs:          []string{n.URL},
+		BaseDebugURLs:     []string{n.URL},
+		Strategy:          rpc.StrategyFailover,
+		MaxFailures:       3,
+		UnhealthyCooldown: time.Second,
+		MaxLag:            10,
+		Timeout:           5 * time.Second,
+		MaxRetries:        2,
+		RetryBaseDelay:    time.Millisecond,
+		RetryMaxDelay:     10 * time.Millisecond,
+		RateBurst:         1,
+	}
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+	Error   *rpc.Error      `json:"error,omitempty"`
+}
+
+func (n *Node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var reqs []request
+		if err := json.Unmarshal(trimmed, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]response, len(reqs))
+		for i, req := range reqs {
+			responses[i] = n.handle(req)
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(n.handle(req))
+}
+
+func (n *Node) handle(req request) response {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.calls[req.Method]++
+
+	res := response{JSONRPC: "2.0", ID: req.ID}
+
+	if n.failures[req.Method] > 0 {
+		n.failures[req.Method]--
+		res.Error = &rpc.Error{Code: -32603, Message: "internal error"}
+		return res
+	}
+	if n.nulls[req.Method] > 0 {
+		n.nulls[req.Method]--
+		return res
+	}
+
+	result, err := n.call(req)
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	res.Result = result
+
+	return res
+}
+
+func (n *Node) call(req request) (any, *rpc.Error) {
+	switch req.Method {
+	case "eth_blockNumber":
+		return hexUint(n.chain.Head()), nil
+	case "eth_getBlockByNumber":
+		var full bool
+		if len(req.Params) > 1 {
+			json.Unmarshal(req.Params[1], &full)
+		}
+		block, err := n.blockParam(req, 0)
+		if err != nil || block == nil {
+			return nil, err
+		}
+		return n.blockResult(block, full), nil
+	case "eth_getBlockReceipts":
+		block, err := n.blockParam(req, 0)
+		if err != nil || block == nil {
+			return nil, err
+		}
+		return receipts(block), nil
+	case "eth_getBalance":
+		var address string
+		if len(req.Params) < 2 || json.Unmarshal(req.Params[0], &address) != nil {
+			return nil, invalidParams("expected address and block")
+		}
+		block, err := n.blockParam(req, 1)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			return nil, &rpc.Error{Code: -32000, Message: "header not found"}
+		}
+		return hexBig(n.chain.Balance(address, block.Number)), nil
+	case "eth_getLogs":
+		return n.logs(req)
+	case "eth_call":
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		if len(req.Params) < 1 || json.Unmarshal(req.Params[0], &call) != nil {
+			return nil, invalidParams("expected call object")
+		}
+		result, found := n.chain.Contracts[strings.ToLower(call.To)][call.Data]
+		if !found {
+			return nil, &rpc.Error{Code: -32000, Message: "execution reverted"}
+		}
+		return result, nil
+	case "debug_traceTransaction":
+		var hash string
+		if len(req.Params) < 1 || json.Unmarshal(req.Params[0], &hash) != nil {
+			return nil, invalidParams("expected transaction hash")
+		}
+		for _, block := range n.chain.Blocks {
+			for _, tx := range block.Transactions {
+				if tx.Hash == hash {
+					return rpc.CallTrace{From: tx.From, To: tx.To, Value: hexBig(tx.Value), Input: tx.Input, Calls: tx.Calls}, nil
+				}
+			}
+		}
+		return nil, &rpc.Error{Code: -32000, Message: fmt.Sprintf("transaction %s not found", hash)}
+	default:
+		return nil, &rpc.Error{Code: -32601, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+	}
+}
+
+// blockParam resolves a block number or tag, the block is nil if the chain doesn't have it (yet).
+func (n *Node) blockParam(req request, i int) (*Block, *rpc.Error) {
+	var tag string
+	if len(req.Params) <= i || json.Unmarshal(req.Params[i], &tag) != nil {
+		return nil, invalidParams("expected block number")
+	}
+
+	if tag == "latest" || tag == "safe" || tag == "finalized" {
+		return n.chain.Block(n.chain.Head()), nil
+	}
+
+	number, err := data.NewHexFromString(tag)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+
+	return n.chain.Block(number.Uint64()), nil
+}
+
+func (n *Node) blockResult(block *Block, full bool) map[string]any {
+	result := map[string]any{
+		"number":     hexUint(block.Number),
+		"hash":       block.Hash,
+		"parentHash": block.ParentHash,
+		"timestamp":  hexUint(uint64(block.Timestamp.Unix())),
+	}
+
+	if full {
+		txs := make([]rpc.Transaction, len(block.Transactions))
+		for i, tx := range block.Transactions {
+			txs[i] = rpc.Transaction{From: tx.From, To: tx.To, Value: hexBig(tx.Value), Input: tx.Input, Hash: tx.Hash}
+		}
+		result["transactions"] = txs
+	} else {
+		hashes := make([]string, len(block.Transactions))
+		for i, tx := range block.Transactions {
+			hashes[i] = tx.Hash
+		}
+		result["transactions"] = hashes
+	}
+
+	return result
+}
+
+func receipts(block *Block) []rpc.Receipt {
+	logs := blockLogs(block)
+
+	receipts := make([]rpc.Receipt, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		receipt := rpc.Receipt{
+			From:              tx.From,
+			To:                tx.To,
+			Status:            "0x1",
+			GasUsed:           hexBig(tx.GasUsed),
+			EffectiveGasPrice: hexBig(tx.EffectiveGasPrice),
+			TransactionHash:   tx.Hash,
+			Logs:              logs[i],
+		}
+		if tx.Failed {
+			receipt.Status = "0x0"
+		}
+		if tx.L1Fee != nil {
+			l1Fee := hexBig(tx.L1Fee)
+			receipt.L1Fee = &l1Fee
+		}
+		receipts[i] = receipt
+	}
+
+	return receipts
+}
+
+// blockLogs fills in the position of the logs of every transaction. Failed transactions don't emit any.
+func blockLogs(block *Block) [][]rpc.Log {
+	logs := make([][]rpc.Log, len(block.Transactions))
+	logIndex := uint64(0)
+
+	for i, tx := range block.Transactions {
+		logs[i] = []rpc.Log{}
+		if tx.Failed {
+			continue
+		}
+
+		for _, l := range tx.Logs {
+			l.BlockNumber = hexUint(block.Number)
+			l.TransactionHash = tx.Hash
+			l.LogIndex = hexUint(logIndex)
+			logIndex++
+			logs[i] = append(logs[i], l)
+		}
+	}
+
+	return logs
+}
+
+func (n *Node) logs(req request) (any, *rpc.Error) {
+	var filter struct {
+		FromBlock string            `json:"fromBlock"`
+		ToBlock   string            `json:"toBlock"`
+		Address   json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	}
+	if len(req.Params) < 1 || json.Unmarshal(req.Params[0], &filter) != nil {
+		return nil, invalidParams("expected filter object")
+	}
+
+	from, err := data.NewHexFromString(filter.FromBlock)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	to, err := data.NewHexFromString(filter.ToBlock)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+
+	addresses, err := anyOf(filter.Address)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	topics := make([][]string, len(filter.Topics))
+	for i, topic := range filter.Topics {
+		if topics[i], err = anyOf(topic); err != nil {
+			return nil, invalidParams(err.Error())
+		}
+	}
+
+	result := []rpc.Log{}
+	for number := from.Uint64(); number <= to.Uint64(); number++ {
+		block := n.chain.Block(number)
+		if block == nil {
+			break
+		}
+
+		for _, txLogs := range blockLogs(block) {
+			for _, l := range txLogs {
+				if matchLog(l, addresses, topics) {
+					result = append(result, l)
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// anyOf decodes a filter position that can be null, a value or a list of values.
+// Nil matches anything.
+func anyOf(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var values []string
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return []string{value}, nil
+}
+
+func matchLog(l rpc.Log, addresses []string, topics [][]string) bool {
+	if addresses != nil && !containsFold(addresses, l.Address) {
+		return false
+	}
+
+	for i, topic := range topics {
+		if topic == nil {
+			continue
+		}
+		if i >= len(l.Topics) || !containsFold(topic, l.Topics[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidParams(message string) *rpc.Error {
+	return &rpc.Error{Code: -32602, Message: message}
+}
+
+func hexUint(n uint64) string {
+	return data.NewHexFromUint64(n).String()
+}
+
+func hexBig(n *big.Int) string {
+	return data.Hex{Int: n}.String()
+}